@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Record is one line of the audit log.
+type Record struct {
+	Time             time.Time `json:"time"`
+	APIKeyName       string    `json:"apiKeyName,omitempty"`
+	ClientIP         string    `json:"clientIp"`
+	Method           string    `json:"method"`
+	Path             string    `json:"path"`
+	Status           int       `json:"status"`
+	Provider         string    `json:"provider,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	Stream           bool      `json:"stream"`
+	LatencyMs        int64     `json:"latencyMs"`
+	PromptTokens     int       `json:"promptTokens,omitempty"`
+	CompletionTokens int       `json:"completionTokens,omitempty"`
+	FinishReason     string    `json:"finishReason,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	// Only written when bodies are enabled
+	Prompt   string `json:"prompt,omitempty"`
+	Response string `json:"response,omitempty"`
+}
+
+type Logger struct {
+	// Whether prompt and response bodies are written
+	IncludeBodies bool
+
+	redactor *Redactor
+	mutex    sync.Mutex
+	out      io.Writer
+}
+
+func NewLogger(out io.Writer, redactor *Redactor, includeBodies bool) *Logger {
+	return &Logger{
+		IncludeBodies: includeBodies,
+		redactor:      redactor,
+		out:           out,
+	}
+}
+
+// Log redacts the record and writes it as one JSON line.
+func (l *Logger) Log(record Record) {
+	if !l.IncludeBodies {
+		record.Prompt = ""
+		record.Response = ""
+	}
+	record.Prompt = l.redactor.Redact(record.Prompt)
+	record.Response = l.redactor.Redact(record.Response)
+	record.Error = l.redactor.Redact(record.Error)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Cannot marshal audit record", "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		slog.Error("Cannot write audit log", "err", err)
+	}
+}
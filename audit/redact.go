@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/cphovo/ollm/util"
+)
+
+const redacted = "[REDACTED]"
+
+// 默认脱敏的凭据格式
+var defaultPatterns = []string{
+	// JWT, e.g. Kimi refresh and access tokens
+	`eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]+`,
+	// Google API keys
+	`AIza[0-9A-Za-z_-]{35}`,
+	// Bearer tokens
+	`(?i)bearer\s+[A-Za-z0-9._~+/=-]{8,}`,
+	// Bing auth cookies
+	`(?i)\b(_U|KievRPSSecAuth|SRCHHPGUSR|_RwBf|cct)=[^;\s"]+`,
+}
+
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles the default credential patterns plus extra ones, e.g. PII like emails or phone numbers.
+func NewRedactor(extra []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, pattern := range append(defaultPatterns, extra...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+func (r *Redactor) Redact(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// ReadRedactPatternsFile reads audit_redact_patterns.json, an array of regular expressions.
+func ReadRedactPatternsFile() ([]string, error) {
+	v, err := os.ReadFile(util.WithPath("audit_redact_patterns.json"))
+	if err != nil {
+		return nil, nil
+	}
+	var patterns []string
+	if err := json.Unmarshal(v, &patterns); err != nil {
+		return nil, fmt.Errorf("failed to json.Unmarshal content of audit redact patterns file: %w", err)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,122 @@
+// Package bypass serves the CAPTCHA bypass protocol of SydneyQt
+// (sydney.BypassServerSolver) with the rod browser of sydney.BrowserSolver.
+package bypass
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/sydney"
+	"github.com/cphovo/ollm/util"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Options struct {
+	// Defaults to https://www.bing.com
+	Bing     string
+	Headless bool
+	// Per challenge, defaults to 60s
+	Timeout time.Duration
+	// Where the screenshots are saved, defaults to a temporary directory
+	ScreenshotDir string
+	// Browsers running at the same time, defaults to 2
+	MaxConcurrent int
+}
+
+type Server struct {
+	options Options
+	solver  sydney.BrowserSolver
+	slots   chan struct{}
+}
+
+// 截图文件名只能是 uuid.png，防止路径穿越
+var screenshotName = regexp.MustCompile(`^[0-9a-f-]{36}\.png$`)
+
+func New(options Options) (*Server, error) {
+	options.Bing = util.Ternary(options.Bing == "", "https://www.bing.com", strings.TrimSuffix(options.Bing, "/"))
+	if options.ScreenshotDir == "" {
+		options.ScreenshotDir = filepath.Join(os.TempDir(), "ollm-captcha-screenshots")
+	}
+	if err := os.MkdirAll(options.ScreenshotDir, 0750); err != nil {
+		return nil, err
+	}
+	return &Server{
+		options: options,
+		solver:  sydney.BrowserSolver{Headless: options.Headless, Timeout: options.Timeout},
+		slots:   make(chan struct{}, util.Ternary(options.MaxConcurrent <= 0, 2, options.MaxConcurrent)),
+	}, nil
+}
+
+// Handler serves the bypass endpoint at / and the screenshots at /screenshots/:name
+func (s *Server) Handler() http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Logger(), gin.Recovery())
+	r.POST("/", s.bypass)
+	r.GET("/screenshots/:name", s.screenshot)
+	return r
+}
+
+func (s *Server) bypass(c *gin.Context) {
+	var request sydney.BypassCaptchaRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cookies := util.ParseCookiesFromString(request.Cookies)
+	if len(cookies) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cookies are required"})
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-c.Request.Context().Done():
+		return
+	}
+
+	slog.Info("Solving captcha", "convId", request.ConvID, "iframeid", request.IFrameID)
+	resCookies, screenshot, err := s.solver.SolveWithScreenshot(c.Request.Context(), sydney.CaptchaChallenge{
+		Cookies:        cookies,
+		ConversationID: request.ConvID,
+		MessageID:      request.RID,
+		IFrameID:       request.IFrameID,
+		Bing:           s.options.Bing,
+	})
+	// 超时时仍然返回截图，客户端会因为没有 cct 而给出截图地址
+	if err != nil && !(errors.Is(err, sydney.ErrCaptchaTimeout) && screenshot != nil) {
+		slog.Warn("Cannot solve captcha", "err", err)
+		c.JSON(http.StatusOK, sydney.BypassCaptchaResponse{Error: err.Error()})
+		return
+	}
+
+	var response sydney.BypassCaptchaResponse
+	response.Result.Cookies = util.FormatCookieString(resCookies)
+	if screenshot != nil {
+		name := uuid.New().String() + ".png"
+		if err := os.WriteFile(filepath.Join(s.options.ScreenshotDir, name), screenshot, 0640); err != nil {
+			slog.Warn("Cannot save captcha screenshot", "err", err)
+		} else {
+			response.Result.ScreenShot = "/screenshots/" + name
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) screenshot(c *gin.Context) {
+	name := c.Param("name")
+	if !screenshotName.MatchString(name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("screenshot not found: %s", name)})
+		return
+	}
+	c.File(filepath.Join(s.options.ScreenshotDir, name))
+}
@@ -0,0 +1,41 @@
+package bypass
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	server, err := New(Options{ScreenshotDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := server.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"IG": "0", "cookies": ""}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("request without cookies: status = %d", w.Code)
+	}
+
+	name := "0d1f4a5e-6a1b-4c43-9a4e-2b0d6e3f9c11.png"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("png"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/screenshots/"+name, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "png" {
+		t.Errorf("screenshot: status = %d, body = %q", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/screenshots/..%2f..%2fetc%2fpasswd", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("path traversal: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Backend is a key value store with per key expiry. A ttl <= 0 never expires.
+type Backend interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	Stats() Stats
+	Close() error
+}
+
+type Stats struct {
+	Backend string `json:"backend"`
+	// Not reported by redis
+	Entries     int    `json:"entries,omitempty"`
+	MaxEntries  int    `json:"maxEntries,omitempty"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+type Config struct {
+	// memory, file or redis
+	Backend    string
+	MaxEntries int
+	// How often expired entries are swept
+	JanitorInterval time.Duration
+	// Snapshot file of the file backend
+	Path string
+	// redis://[:password@]host:port[/db]
+	RedisURL string
+	// Prefix of every key stored in redis
+	Prefix string
+}
+
+func New(config Config) (Backend, error) {
+	switch config.Backend {
+	case "", "memory":
+		return NewMemory(config.MaxEntries, config.JanitorInterval), nil
+	case "file":
+		return NewFile(config.Path, config.MaxEntries, config.JanitorInterval)
+	case "redis":
+		return NewRedis(config.RedisURL, config.Prefix)
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %s", config.Backend)
+	}
+}
+
+// GetJSON decodes the cached value into v.
+func GetJSON(b Backend, key string, v any) (bool, error) {
+	value, ok, err := b.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func SetJSON(b Backend, key string, v any, ttl time.Duration) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Set(key, value, ttl)
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryExpiry(t *testing.T) {
+	m := NewMemory(0, 0)
+	defer m.Close()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	m.Set("a", []byte("1"), time.Minute)
+	m.Set("b", []byte("2"), 0)
+	if v, ok, _ := m.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("a = %q, %v", v, ok)
+	}
+
+	now = now.Add(time.Minute)
+	// 过期读取不能死锁
+	if _, ok, _ := m.Get("a"); ok {
+		t.Fatal("a should be expired")
+	}
+	if _, ok, _ := m.Get("b"); !ok {
+		t.Fatal("b should never expire")
+	}
+
+	stats := m.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Expirations != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMemoryJanitor(t *testing.T) {
+	m := NewMemory(0, 10*time.Millisecond)
+	defer m.Close()
+
+	m.Set("a", []byte("1"), time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if n := m.Stats().Entries; n != 0 {
+		t.Fatalf("janitor left %d entries", n)
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	m := NewMemory(2, 0)
+	defer m.Close()
+
+	m.Set("a", []byte("1"), 0)
+	m.Set("b", []byte("2"), 0)
+	m.Get("a")
+	m.Set("c", []byte("3"), 0)
+
+	if _, ok, _ := m.Get("b"); ok {
+		t.Fatal("least recently used entry b should be evicted")
+	}
+	if _, ok, _ := m.Get("a"); !ok {
+		t.Fatal("a should be kept")
+	}
+	if stats := m.Stats(); stats.Evictions != 1 {
+		t.Fatalf("evictions = %d", stats.Evictions)
+	}
+}
+
+func TestFilePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	f, err := NewFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetJSON(f, "token", map[string]string{"access_token": "x"}, time.Hour)
+	f.Set("expired", []byte("1"), time.Nanosecond)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = NewFile(path, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var token map[string]string
+	if ok, err := GetJSON(f, "token", &token); !ok || err != nil || token["access_token"] != "x" {
+		t.Fatalf("token = %v, %v, %v", token, ok, err)
+	}
+	if _, ok, _ := f.Get("expired"); ok {
+		t.Fatal("expired entry should not be loaded")
+	}
+}
+
+// fakeRedis understands just enough RESP for the backend.
+func fakeRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						header, _ := reader.ReadString('\n')
+						size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+						arg := make([]byte, size+2)
+						io.ReadFull(reader, arg)
+						args[i] = string(arg[:size])
+					}
+					switch args[0] {
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							fmt.Fprint(conn, "$-1\r\n")
+						}
+					case "SET":
+						data[args[1]] = args[2]
+						fmt.Fprint(conn, "+OK\r\n")
+					case "DEL":
+						delete(data, args[1])
+						fmt.Fprint(conn, ":1\r\n")
+					default:
+						fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRedis(t *testing.T) {
+	r, err := NewRedis("redis://"+fakeRedis(t), "ollm:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.Set("a", []byte("hello\r\nworld"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := r.Get("a"); !ok || err != nil || string(v) != "hello\r\nworld" {
+		t.Fatalf("a = %q, %v, %v", v, ok, err)
+	}
+	r.Delete("a")
+	if _, ok, err := r.Get("a"); ok || err != nil {
+		t.Fatalf("a should be deleted, %v", err)
+	}
+	if stats := r.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
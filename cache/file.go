@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type fileEntry struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// File is a memory cache that is saved to a JSON snapshot, so entries survive
+// restarts. The snapshot is written on every janitor tick when something
+// changed, and on Flush/Close.
+type File struct {
+	*Memory
+	path string
+
+	flushMutex sync.Mutex
+	dirty      bool
+	stop       chan struct{}
+	once       sync.Once
+}
+
+func NewFile(path string, maxEntries int, janitorInterval time.Duration) (*File, error) {
+	if path == "" {
+		return nil, errors.New("cache file path is required")
+	}
+	f := &File{
+		Memory: NewMemory(maxEntries, 0),
+		path:   path,
+		stop:   make(chan struct{}),
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if janitorInterval <= 0 {
+		janitorInterval = time.Minute
+	}
+	go f.janitor(janitorInterval)
+	return f, nil
+}
+
+func (f *File) load() error {
+	v, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []fileEntry
+	if err := json.Unmarshal(v, &entries); err != nil {
+		return fmt.Errorf("failed to json.Unmarshal content of cache file: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	now := f.now()
+	for _, e := range entries {
+		e := &entry{key: e.Key, value: e.Value, expiresAt: e.ExpiresAt}
+		if !e.expired(now) {
+			f.setEntry(e)
+		}
+	}
+	return nil
+}
+
+func (f *File) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.DeleteExpired()
+			if err := f.Flush(); err != nil {
+				slog.Error("Cannot save cache file", "path", f.path, "err", err)
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *File) Set(key string, value []byte, ttl time.Duration) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.set(key, value, ttl)
+	f.dirty = true
+	return nil
+}
+
+func (f *File) Delete(key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if element, ok := f.entries[key]; ok {
+		f.remove(element)
+		f.dirty = true
+	}
+	return nil
+}
+
+// Flush writes the snapshot if anything changed since the last write.
+func (f *File) Flush() error {
+	f.flushMutex.Lock()
+	defer f.flushMutex.Unlock()
+
+	f.mutex.Lock()
+	if !f.dirty {
+		f.mutex.Unlock()
+		return nil
+	}
+	entries := make([]fileEntry, 0, f.lru.Len())
+	// 从最久未使用的开始写，加载时保持 LRU 顺序
+	for element := f.lru.Back(); element != nil; element = element.Prev() {
+		e := element.Value.(*entry)
+		entries = append(entries, fileEntry{Key: e.key, Value: e.value, ExpiresAt: e.expiresAt})
+	}
+	f.dirty = false
+	f.mutex.Unlock()
+
+	if err := f.write(entries); err != nil {
+		f.mutex.Lock()
+		f.dirty = true
+		f.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (f *File) write(entries []fileEntry) error {
+	v, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免写到一半时进程退出损坏快照
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(v); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *File) Stats() Stats {
+	stats := f.Memory.Stats()
+	stats.Backend = "file"
+	return stats
+}
+
+func (f *File) Close() error {
+	f.once.Do(func() { close(f.stop) })
+	f.Memory.Close()
+	return f.Flush()
+}
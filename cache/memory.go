@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero means never
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// Memory is an in-memory LRU cache. Expired entries are removed on access and
+// by a background janitor, and the least recently used entry is evicted when
+// MaxEntries is exceeded.
+type Memory struct {
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+	stats   Stats
+	now     func() time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewMemory creates a memory cache. maxEntries <= 0 means unbounded, and
+// janitorInterval <= 0 disables the background sweep.
+func NewMemory(maxEntries int, janitorInterval time.Duration) *Memory {
+	m := &Memory{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		lru:        list.New(),
+		now:        time.Now,
+		stop:       make(chan struct{}),
+	}
+	if janitorInterval > 0 {
+		go m.janitor(janitorInterval)
+	}
+	return m
+}
+
+func (m *Memory) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.DeleteExpired()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		m.stats.Misses++
+		return nil, false, nil
+	}
+	e := element.Value.(*entry)
+	if e.expired(m.now()) {
+		m.remove(element)
+		m.stats.Expirations++
+		m.stats.Misses++
+		return nil, false, nil
+	}
+	m.lru.MoveToFront(element)
+	m.stats.Hits++
+	return e.value, true, nil
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.set(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) set(key string, value []byte, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = m.now().Add(ttl)
+	}
+	m.setEntry(&entry{key: key, value: value, expiresAt: expiresAt})
+}
+
+func (m *Memory) setEntry(e *entry) {
+	if element, ok := m.entries[e.key]; ok {
+		element.Value = e
+		m.lru.MoveToFront(element)
+		return
+	}
+	m.entries[e.key] = m.lru.PushFront(e)
+	for m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+		m.stats.Evictions++
+	}
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		m.remove(element)
+	}
+	return nil
+}
+
+// DeleteExpired removes every expired entry.
+func (m *Memory) DeleteExpired() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	for _, element := range m.entries {
+		if element.Value.(*entry).expired(now) {
+			m.remove(element)
+			m.stats.Expirations++
+		}
+	}
+}
+
+// Must be called with the mutex held.
+func (m *Memory) remove(element *list.Element) {
+	m.lru.Remove(element)
+	delete(m.entries, element.Value.(*entry).key)
+}
+
+func (m *Memory) Stats() Stats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := m.stats
+	stats.Backend = "memory"
+	stats.Entries = len(m.entries)
+	stats.MaxEntries = m.maxEntries
+	return stats
+}
+
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis stores entries in a Redis compatible server (Redis, KeyDB, Valkey,
+// Dragonfly...) speaking RESP, so several ollm replicas can share them.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	stats  Stats
+}
+
+var (
+	errNil        = errors.New("redis: nil")
+	errConnection = errors.New("redis: connection closed")
+)
+
+// NewRedis connects to redis://[:password@]host:port[/db].
+func NewRedis(rawURL, prefix string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid redis url scheme: %s", u.Scheme)
+	}
+	r := &Redis{
+		addr:    u.Host,
+		prefix:  prefix,
+		timeout: 5 * time.Second,
+	}
+	if !strings.Contains(r.addr, ":") {
+		r.addr += ":6379"
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis db: %s", db)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Must be called with the mutex held.
+func (r *Redis) connect() error {
+	conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+	if r.password != "" {
+		if _, err := r.do("AUTH", r.password); err != nil {
+			r.closeConn()
+			return err
+		}
+	}
+	if r.db != 0 {
+		if _, err := r.do("SELECT", strconv.Itoa(r.db)); err != nil {
+			r.closeConn()
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Redis) closeConn() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// command sends one command, reconnecting once if the connection was lost.
+func (r *Redis) command(args ...string) (any, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if r.conn == nil {
+			if err := r.connect(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := r.do(args...)
+		var netErr net.Error
+		if err != nil && attempt == 0 && (errors.As(err, &netErr) || errors.Is(err, net.ErrClosed) || errors.Is(err, errConnection)) {
+			r.closeConn()
+			continue
+		}
+		return reply, err
+	}
+}
+
+// Must be called with the mutex held.
+func (r *Redis) do(args ...string) (any, error) {
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := r.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return r.readReply()
+}
+
+func (r *Redis) readReply() (any, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, errors.Join(errConnection, err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return nil, errors.Join(errConnection, err)
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = r.readReply(); err != nil && !errors.Is(err, errNil) {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (r *Redis) Get(key string) ([]byte, bool, error) {
+	reply, err := r.command("GET", r.prefix+key)
+	if errors.Is(err, errNil) {
+		r.count(false)
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+	r.count(true)
+	return value, true, nil
+}
+
+func (r *Redis) count(hit bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if hit {
+		r.stats.Hits++
+	} else {
+		r.stats.Misses++
+	}
+}
+
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", r.prefix + key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	_, err := r.command(args...)
+	return err
+}
+
+func (r *Redis) Delete(key string) error {
+	_, err := r.command("DEL", r.prefix+key)
+	return err
+}
+
+// Stats only counts the hits and misses of this process, expiry and eviction
+// are handled by the server. Entries is not reported, DBSIZE would count the
+// keys of the whole database rather than the ones of the prefix.
+func (r *Redis) Stats() Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats := r.stats
+	stats.Backend = "redis"
+	return stats
+}
+
+func (r *Redis) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closeConn()
+	return nil
+}
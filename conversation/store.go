@@ -0,0 +1,215 @@
+package conversation
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+var ErrNotFound = errors.New("conversation not found")
+
+type Conversation struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Provider string `json:"provider"` // bing, kimi or gemini
+	Model    string `json:"model"`
+	// Name of the API key that created the conversation, empty without authentication
+	Owner string `json:"owner"`
+	// Id of the conversation on the provider side, e.g. Bing conversationId or Kimi convId
+	UpstreamID string    `json:"upstreamId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Messages   []Message `json:"messages,omitempty"`
+}
+
+type Message struct {
+	ID             int64  `json:"id"`
+	ConversationID string `json:"conversationId"`
+	Role           string `json:"role"`
+	Content        string `json:"content"`
+	// Upstream conversation the message was sent in
+	UpstreamID string    `json:"upstreamId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS conversations (
+	id          TEXT PRIMARY KEY,
+	title       TEXT NOT NULL DEFAULT '',
+	provider    TEXT NOT NULL DEFAULT '',
+	model       TEXT NOT NULL DEFAULT '',
+	owner       TEXT NOT NULL DEFAULT '',
+	upstream_id TEXT NOT NULL DEFAULT '',
+	created_at  INTEGER NOT NULL,
+	updated_at  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+	role            TEXT NOT NULL,
+	content         TEXT NOT NULL,
+	upstream_id     TEXT NOT NULL DEFAULT '',
+	created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_conversation_id ON messages(conversation_id, id);
+CREATE INDEX IF NOT EXISTS conversations_owner ON conversations(owner, updated_at);
+`
+
+// Open opens (and creates if needed) the SQLite database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to init conversation database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Create(conversation Conversation) (Conversation, error) {
+	now := time.Now()
+	conversation.ID = uuid.New().String()
+	conversation.CreatedAt = now
+	conversation.UpdatedAt = now
+	_, err := s.db.Exec(`INSERT INTO conversations (id, title, provider, model, owner, upstream_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		conversation.ID, conversation.Title, conversation.Provider, conversation.Model, conversation.Owner, conversation.UpstreamID,
+		now.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return Conversation{}, err
+	}
+	return conversation, nil
+}
+
+// Get returns the conversation of owner with all its messages. Conversations
+// of other owners are reported as ErrNotFound.
+func (s *Store) Get(id, owner string) (Conversation, error) {
+	var conversation Conversation
+	var createdAt, updatedAt int64
+	err := s.db.QueryRow(`SELECT id, title, provider, model, owner, upstream_id, created_at, updated_at
+		FROM conversations WHERE id = ? AND owner = ?`, id, owner).
+		Scan(&conversation.ID, &conversation.Title, &conversation.Provider, &conversation.Model,
+			&conversation.Owner, &conversation.UpstreamID, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Conversation{}, ErrNotFound
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+	conversation.CreatedAt = time.UnixMilli(createdAt)
+	conversation.UpdatedAt = time.UnixMilli(updatedAt)
+
+	rows, err := s.db.Query(`SELECT id, conversation_id, role, content, upstream_id, created_at
+		FROM messages WHERE conversation_id = ? ORDER BY id`, id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var message Message
+		if err := rows.Scan(&message.ID, &message.ConversationID, &message.Role, &message.Content,
+			&message.UpstreamID, &createdAt); err != nil {
+			return Conversation{}, err
+		}
+		message.CreatedAt = time.UnixMilli(createdAt)
+		conversation.Messages = append(conversation.Messages, message)
+	}
+	return conversation, rows.Err()
+}
+
+// List returns conversations of owner without messages, most recently updated first.
+func (s *Store) List(owner string, limit, offset int) ([]Conversation, error) {
+	rows, err := s.db.Query(`SELECT id, title, provider, model, owner, upstream_id, created_at, updated_at
+		FROM conversations WHERE owner = ? ORDER BY updated_at DESC LIMIT ? OFFSET ?`, owner, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	conversations := []Conversation{}
+	for rows.Next() {
+		var conversation Conversation
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&conversation.ID, &conversation.Title, &conversation.Provider, &conversation.Model,
+			&conversation.Owner, &conversation.UpstreamID, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		conversation.CreatedAt = time.UnixMilli(createdAt)
+		conversation.UpdatedAt = time.UnixMilli(updatedAt)
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+// Update saves title, provider, model and upstream id of the conversation.
+// The owner cannot be changed.
+func (s *Store) Update(conversation Conversation) error {
+	result, err := s.db.Exec(`UPDATE conversations SET title = ?, provider = ?, model = ?, upstream_id = ?, updated_at = ?
+		WHERE id = ? AND owner = ?`,
+		conversation.Title, conversation.Provider, conversation.Model, conversation.UpstreamID,
+		time.Now().UnixMilli(), conversation.ID, conversation.Owner)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+func (s *Store) Delete(id, owner string) error {
+	result, err := s.db.Exec(`DELETE FROM conversations WHERE id = ? AND owner = ?`, id, owner)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// AddMessages appends messages to the conversation in one transaction.
+func (s *Store) AddMessages(id string, messages ...Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	result, err := tx.Exec(`UPDATE conversations SET updated_at = ? WHERE id = ?`, now, id)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+	for _, message := range messages {
+		_, err := tx.Exec(`INSERT INTO messages (conversation_id, role, content, upstream_id, created_at)
+			VALUES (?, ?, ?, ?, ?)`, id, message.Role, message.Content, message.UpstreamID, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package conversation
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	conversation, err := store.Create(Conversation{Title: "test", Provider: "kimi", Model: "kimi", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.AddMessages(conversation.ID,
+		Message{Role: "user", Content: "hello"},
+		Message{Role: "assistant", Content: "hi", UpstreamID: "conv-1"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation.UpstreamID = "conv-1"
+	if err := store.Update(conversation); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(conversation.ID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UpstreamID != "conv-1" || len(got.Messages) != 2 || got.Messages[1].Content != "hi" {
+		t.Errorf("unexpected conversation %+v", got)
+	}
+
+	list, err := store.List("alice", 10, 0)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("expected one conversation, got %v %v", list, err)
+	}
+
+	// 其他 API key 看不到也改不了这个会话
+	if list, err := store.List("bob", 10, 0); err != nil || len(list) != 0 {
+		t.Errorf("expected no conversations for bob, got %v %v", list, err)
+	}
+	if _, err := store.Get(conversation.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	stolen := got
+	stolen.Owner = "bob"
+	if err := store.Update(stolen); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.Delete(conversation.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.Delete(conversation.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(conversation.ID, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := store.AddMessages(conversation.ID, Message{Role: "user", Content: "hello"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package gemini
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// Transport 为 nil 时使用 SDK 默认的 transport，测试中替换为录制回放的 transport
+var Transport http.RoundTripper
+
+// newClient creates a client for endpoint, an empty endpoint is the SDK default.
+// tail (optional) records the end of the streamed responses, see isStreamEnd.
+func newClient(ctx context.Context, apiKey, endpoint string, tail *streamTail) (*genai.Client, error) {
+	var opts []option.ClientOption
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	if Transport == nil && tail == nil {
+		return genai.NewClient(ctx, append(opts, option.WithAPIKey(apiKey))...)
+	}
+	base := Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// 自定义 http.Client 时 SDK 不会再附加 API Key
+	return genai.NewClient(ctx, append(opts, option.WithHTTPClient(&http.Client{
+		Transport: &apiKeyTransport{apiKey: apiKey, base: base, tail: tail},
+	}))...)
+}
+
+type apiKeyTransport struct {
+	apiKey string
+	base   http.RoundTripper
+	tail   *streamTail
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", t.apiKey)
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && t.tail != nil {
+		resp.Body = &tailReader{ReadCloser: resp.Body, tail: t.tail}
+	}
+	return resp, err
+}
+
+// streamTail remembers the last two non-whitespace bytes of a response and
+// the offset of the last one.
+type streamTail struct {
+	mutex      sync.Mutex
+	offset     int64
+	last, prev byte
+	lastOffset int64
+}
+
+func (t *streamTail) write(p []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, b := range p {
+		t.offset++
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		t.prev, t.last, t.lastOffset = t.last, b, t.offset
+	}
+}
+
+// closesArray reports whether the byte before offset is the last one read, a
+// ']' right after an element or the '[' of an empty array.
+func (t *streamTail) closesArray(offset int64) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.last == ']' && t.lastOffset == offset && (t.prev == '}' || t.prev == '[')
+}
+
+type tailReader struct {
+	io.ReadCloser
+	tail *streamTail
+}
+
+func (r *tailReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.tail.write(p[:n])
+	return n, err
+}
@@ -0,0 +1,62 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cphovo/ollm/tracing"
+	"github.com/google/generative-ai-go/genai"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Gemini accepts at most 100 contents in one batchEmbedContents call
+const maxEmbedBatchSize = 100
+
+type EmbedOptions struct {
+	APIKey   string
+	Model    string
+	Input    []string
+	Endpoint string
+}
+
+// Embed returns one vector per input, in the same order.
+func Embed(ctx context.Context, options EmbedOptions) (embeddings [][]float32, err error) {
+	if len(options.Input) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	ctx, span := tracing.Start(ctx, "gemini.embed",
+		attribute.String("gemini.model", options.Model),
+		attribute.Int("gemini.inputs", len(options.Input)))
+	defer func() { tracing.End(span, err) }()
+
+	client, err := newClient(ctx, options.APIKey, options.Endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	model := client.EmbeddingModel(options.Model)
+
+	for start := 0; start < len(options.Input); start += maxEmbedBatchSize {
+		end := min(start+maxEmbedBatchSize, len(options.Input))
+
+		batch := model.NewBatch()
+		for _, text := range options.Input[start:end] {
+			batch.AddContent(genai.Text(text))
+		}
+
+		resp, err := model.BatchEmbedContents(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, errors.New("gemini returned a different number of embeddings than inputs")
+		}
+		for _, embedding := range resp.Embeddings {
+			embeddings = append(embeddings, embedding.Values)
+		}
+	}
+
+	return embeddings, nil
+}
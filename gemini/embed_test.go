@@ -0,0 +1,51 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cphovo/ollm/replay"
+	"github.com/cphovo/ollm/util"
+)
+
+func TestEmbed(t *testing.T) {
+	session, err := replay.Start("testdata/embed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Transport = session.Transport(nil)
+	t.Cleanup(func() {
+		Transport = nil
+		if err := session.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// 101 条输入分成 100 和 1 两批请求
+	var input []string
+	for i := 0; i < 101; i++ {
+		input = append(input, fmt.Sprintf("text %d", i))
+	}
+	embeddings, err := Embed(context.Background(), EmbedOptions{
+		APIKey: util.Ternary(os.Getenv("GEMINI_API_KEY") == "", "test", os.Getenv("GEMINI_API_KEY")),
+		Model:  "text-embedding-004",
+		Input:  input,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embeddings) != len(input) {
+		t.Fatalf("got %d embeddings, want %d", len(embeddings), len(input))
+	}
+	if session.Recording() {
+		return
+	}
+	// 向量按输入的顺序返回
+	for i, values := range embeddings {
+		if len(values) != 3 || values[0] != float32(i) {
+			t.Errorf("embedding %d = %v", i, values)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package gemini
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cphovo/ollm/metrics"
+	"google.golang.org/api/googleapi"
+)
+
+var ErrNoAvailableKey = errors.New("all gemini api keys are rate limited or cooling down")
+
+// KeyPool rotates requests over several Gemini API keys. Every key has its own
+// requests per minute / per day budget, and a key that hits 429
+// RESOURCE_EXHAUSTED is put into cooldown while the request is retried on the
+// next key.
+type KeyPool struct {
+	// Zero means unlimited
+	RPM int
+	RPD int
+	// How long a key rests after a quota error
+	Cooldown time.Duration
+
+	mutex sync.Mutex
+	keys  []*keyState
+	next  int
+	now   func() time.Time
+}
+
+type keyState struct {
+	key           string
+	minute        []time.Time // requests in the last minute
+	dayStart      time.Time
+	dayCount      int
+	cooldownUntil time.Time
+	total         int
+	quotaErrors   int
+	lastError     string
+}
+
+type KeyStatus struct {
+	Key                string    `json:"key"`
+	Available          bool      `json:"available"`
+	RequestsLastMinute int       `json:"requestsLastMinute"`
+	RequestsToday      int       `json:"requestsToday"`
+	CooldownUntil      time.Time `json:"cooldownUntil,omitempty"`
+	TotalRequests      int       `json:"totalRequests"`
+	QuotaErrors        int       `json:"quotaErrors"`
+	LastError          string    `json:"lastError,omitempty"`
+}
+
+func NewKeyPool(keys []string, rpm, rpd int, cooldown time.Duration) *KeyPool {
+	pool := &KeyPool{
+		RPM:      rpm,
+		RPD:      rpd,
+		Cooldown: cooldown,
+		now:      time.Now,
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		pool.keys = append(pool.keys, &keyState{key: key})
+	}
+	return pool
+}
+
+func (p *KeyPool) Len() int {
+	return len(p.keys)
+}
+
+// refresh drops the requests that fell out of the minute and day windows.
+// Must be called with the mutex held.
+func (p *KeyPool) refresh(state *keyState, now time.Time) {
+	i := 0
+	for i < len(state.minute) && now.Sub(state.minute[i]) >= time.Minute {
+		i++
+	}
+	state.minute = state.minute[i:]
+	if now.Sub(state.dayStart) >= 24*time.Hour {
+		state.dayStart = now
+		state.dayCount = 0
+	}
+}
+
+func (p *KeyPool) available(state *keyState, now time.Time) bool {
+	if now.Before(state.cooldownUntil) {
+		return false
+	}
+	if p.RPM > 0 && len(state.minute) >= p.RPM {
+		return false
+	}
+	if p.RPD > 0 && state.dayCount >= p.RPD {
+		return false
+	}
+	return true
+}
+
+// Acquire picks the next usable key in round-robin order and counts a request
+// against it. Keys in tried are skipped.
+func (p *KeyPool) Acquire(tried map[string]bool) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	for i := 0; i < len(p.keys); i++ {
+		state := p.keys[(p.next+i)%len(p.keys)]
+		if tried[state.key] {
+			continue
+		}
+		p.refresh(state, now)
+		if !p.available(state, now) {
+			continue
+		}
+		p.next = (p.next + i + 1) % len(p.keys)
+		state.minute = append(state.minute, now)
+		state.dayCount++
+		state.total++
+		return state.key, nil
+	}
+	return "", ErrNoAvailableKey
+}
+
+// ReportQuotaExceeded puts the key into cooldown.
+func (p *KeyPool) ReportQuotaExceeded(key string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, state := range p.keys {
+		if state.key != key {
+			continue
+		}
+		metrics.Throttles.WithLabelValues("gemini").Inc()
+		state.cooldownUntil = p.now().Add(p.Cooldown)
+		state.quotaErrors++
+		state.lastError = err.Error()
+		slog.Warn("Gemini api key exhausted, cooling down", "key", maskKey(key), "until", state.cooldownUntil)
+	}
+}
+
+// Do calls fn with a key from the pool, and retries with the next key as long
+// as fn fails with a quota error.
+func (p *KeyPool) Do(fn func(apiKey string) error) error {
+	tried := map[string]bool{}
+	var lastErr error
+	for {
+		key, err := p.Acquire(tried)
+		if err != nil {
+			if lastErr != nil {
+				return errors.Join(err, lastErr)
+			}
+			return err
+		}
+		tried[key] = true
+
+		err = fn(key)
+		if err == nil || !IsQuotaError(err) {
+			return err
+		}
+		p.ReportQuotaExceeded(key, err)
+		lastErr = err
+	}
+}
+
+func (p *KeyPool) Status() []KeyStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	var status []KeyStatus
+	for _, state := range p.keys {
+		p.refresh(state, now)
+		s := KeyStatus{
+			Key:                maskKey(state.key),
+			Available:          p.available(state, now),
+			RequestsLastMinute: len(state.minute),
+			RequestsToday:      state.dayCount,
+			TotalRequests:      state.total,
+			QuotaErrors:        state.quotaErrors,
+			LastError:          state.lastError,
+		}
+		if now.Before(state.cooldownUntil) {
+			s.CooldownUntil = state.cooldownUntil
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// IsQuotaError reports whether Gemini rejected the request with 429 RESOURCE_EXHAUSTED.
+func IsQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	return strings.Contains(err.Error(), "RESOURCE_EXHAUSTED")
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
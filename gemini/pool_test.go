@@ -0,0 +1,70 @@
+package gemini
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestKeyPoolRotation(t *testing.T) {
+	now := time.Now()
+	pool := NewKeyPool([]string{"key-a", "key-b"}, 2, 0, time.Minute)
+	pool.now = func() time.Time { return now }
+
+	var keys []string
+	for i := 0; i < 4; i++ {
+		key, err := pool.Acquire(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if keys[0] != "key-a" || keys[1] != "key-b" || keys[2] != "key-a" || keys[3] != "key-b" {
+		t.Errorf("keys should be used in turn, got %v", keys)
+	}
+	if _, err := pool.Acquire(nil); !errors.Is(err, ErrNoAvailableKey) {
+		t.Errorf("expected the rpm limit to be reached, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := pool.Acquire(nil); err != nil {
+		t.Errorf("keys should be usable again after a minute, got %v", err)
+	}
+}
+
+func TestKeyPoolDoRetriesOnQuotaError(t *testing.T) {
+	pool := NewKeyPool([]string{"key-a", "key-b"}, 0, 0, time.Minute)
+
+	var used []string
+	err := pool.Do(func(apiKey string) error {
+		used = append(used, apiKey)
+		if apiKey == "key-a" {
+			return &googleapi.Error{Code: http.StatusTooManyRequests, Message: "RESOURCE_EXHAUSTED"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(used) != 2 || used[1] != "key-b" {
+		t.Fatalf("expected a retry on key-b, got %v", used)
+	}
+
+	status := pool.Status()
+	if status[0].Available || status[0].QuotaErrors != 1 {
+		t.Errorf("key-a should be cooling down, got %+v", status[0])
+	}
+
+	// key-a is cooling down, so only key-b is left
+	used = nil
+	err = pool.Do(func(apiKey string) error {
+		used = append(used, apiKey)
+		return errors.New("RESOURCE_EXHAUSTED")
+	})
+	if !errors.Is(err, ErrNoAvailableKey) || len(used) != 1 {
+		t.Errorf("expected the pool to run out of keys after key-b, got %v %v", err, used)
+	}
+}
@@ -2,70 +2,221 @@ package gemini
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"strings"
 
+	"github.com/cphovo/ollm/tracing"
 	"github.com/google/generative-ai-go/genai"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 )
 
 type AskStreamOptions struct {
 	APIKey string
 	Model  string
 	Prompt string
+	// Base url of the REST API, empty is https://generativelanguage.googleapis.com
+	Endpoint string
+
+	// Generation config, nil means the model default
+	Temperature      *float32
+	TopP             *float32
+	MaxOutputTokens  *int32
+	StopSequences    []string
+	CandidateCount   *int32
+	ResponseMIMEType string
+	SafetySettings   []*genai.SafetySetting
 }
 
 type Message struct {
 	Event string
 	Text  string
 	Error error
+	// Index of the candidate the text belongs to
+	Index int
+	// Set on the last message of a candidate
+	FinishReason genai.FinishReason
+}
+
+type Choice struct {
+	Index        int
+	Text         string
+	FinishReason genai.FinishReason
+}
+
+// PromptBlockedError is returned when Gemini refuses the prompt itself, so no
+// candidate was generated at all.
+type PromptBlockedError struct {
+	Reason genai.BlockReason
+}
+
+func (e *PromptBlockedError) Error() string {
+	reason := "OTHER"
+	if e.Reason == genai.BlockReasonSafety {
+		reason = "SAFETY"
+	}
+	return "prompt blocked by gemini: " + reason
+}
+
+func (options AskStreamOptions) newModel(client *genai.Client) *genai.GenerativeModel {
+	model := client.GenerativeModel(options.Model)
+	model.Temperature = options.Temperature
+	model.TopP = options.TopP
+	model.MaxOutputTokens = options.MaxOutputTokens
+	model.StopSequences = options.StopSequences
+	model.CandidateCount = options.CandidateCount
+	model.ResponseMIMEType = options.ResponseMIMEType
+	model.SafetySettings = options.SafetySettings
+	return model
+}
+
+// The SDK reports blocked prompts and candidates finished by SAFETY or
+// RECITATION as a *genai.BlockedError instead of a response. A blocked
+// candidate is turned back into a choice so it can be reported as a finish
+// reason, a blocked prompt becomes a PromptBlockedError.
+func blockedCandidate(err error) (*genai.Candidate, error) {
+	var blocked *genai.BlockedError
+	if !errors.As(err, &blocked) {
+		return nil, err
+	}
+	if blocked.PromptFeedback != nil {
+		return nil, &PromptBlockedError{Reason: blocked.PromptFeedback.BlockReason}
+	}
+	if blocked.Candidate == nil {
+		return nil, err
+	}
+	return blocked.Candidate, nil
+}
+
+// With the json v2 backed encoding/json, the JSON array stream reader of the
+// SDK reports the closing ']' as a syntax error instead of io.EOF. Only that
+// error at the trailing ']' of the response ends the stream, any other
+// syntax error is a broken response.
+func isStreamEnd(err error, tail *streamTail) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) &&
+		strings.Contains(syntaxErr.Error(), "']' looking for beginning of value") &&
+		tail.closesArray(syntaxErr.Offset)
+}
+
+func candidateText(candidate *genai.Candidate) string {
+	if candidate.Content == nil {
+		return ""
+	}
+	var builder strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			builder.WriteString(string(text))
+		}
+	}
+	return builder.String()
 }
 
-func Ask(options AskStreamOptions) (string, error) {
-	ctx := context.Background()
+func candidateMessage(candidate *genai.Candidate) Message {
+	return Message{
+		Event:        "message",
+		Text:         candidateText(candidate),
+		Index:        int(candidate.Index),
+		FinishReason: candidate.FinishReason,
+	}
+}
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(options.APIKey))
+func Ask(ctx context.Context, options AskStreamOptions) (choices []Choice, err error) {
+	ctx, span := tracing.Start(ctx, "gemini.generate", attribute.String("gemini.model", options.Model))
+	defer func() {
+		span.SetAttributes(attribute.Int("gemini.candidates", len(choices)))
+		tracing.End(span, err)
+	}()
+
+	client, err := newClient(ctx, options.APIKey, options.Endpoint, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer client.Close()
 
-	model := client.GenerativeModel(options.Model)
+	model := options.newModel(client)
 	resp, err := model.GenerateContent(ctx, genai.Text(options.Prompt))
 	if err != nil {
-		return "", err
+		candidate, err := blockedCandidate(err)
+		if err != nil {
+			return nil, err
+		}
+		resp = &genai.GenerateContentResponse{Candidates: []*genai.Candidate{candidate}}
 	}
-	return fmt.Sprint(resp.Candidates[0].Content.Parts[0]), err
+
+	for _, candidate := range resp.Candidates {
+		choices = append(choices, Choice{
+			Index:        int(candidate.Index),
+			Text:         candidateText(candidate),
+			FinishReason: candidate.FinishReason,
+		})
+	}
+	if len(choices) == 0 {
+		return nil, errors.New("empty response from gemini")
+	}
+	return choices, nil
 }
 
-func AskStream(options AskStreamOptions) (<-chan Message, error) {
-	ctx := context.Background()
+func AskStream(ctx context.Context, options AskStreamOptions) (<-chan Message, error) {
+	// span 在流结束时才结束
+	ctx, span := tracing.Start(ctx, "gemini.stream", attribute.String("gemini.model", options.Model))
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(options.APIKey))
+	tail := &streamTail{}
+	client, err := newClient(ctx, options.APIKey, options.Endpoint, tail)
 	if err != nil {
+		tracing.End(span, err)
 		return nil, err
 	}
-	defer client.Close()
 
-	model := client.GenerativeModel(options.Model)
+	model := options.newModel(client)
 	iter := model.GenerateContentStream(ctx, genai.Text(options.Prompt))
 
+	// 先读取第一个响应，这样请求本身的错误（例如 prompt 被拦截）可以直接返回
+	resp, err := iter.Next()
+	if err != nil && err != iterator.Done && !isStreamEnd(err, tail) {
+		if _, err := blockedCandidate(err); err != nil {
+			client.Close()
+			tracing.End(span, err)
+			return nil, err
+		}
+	}
+
 	messageChan := make(chan Message)
 
 	go func() {
+		var streamErr error
+		defer func() { tracing.End(span, streamErr) }()
 		defer close(messageChan)
+		defer client.Close()
 		for {
-			resp, err := iter.Next()
-			if err == iterator.Done {
+			if err == iterator.Done || isStreamEnd(err, tail) {
 				break
 			}
 			if err != nil {
-				messageChan <- Message{Error: err, Event: "error"}
+				candidate, err := blockedCandidate(err)
+				if err != nil {
+					streamErr = err
+					// 请求被取消时由调用方处理
+					if ctx.Err() != nil {
+						return
+					}
+					messageChan <- Message{Error: err, Text: err.Error(), Event: "error"}
+					return
+				}
+				messageChan <- candidateMessage(candidate)
 				return
 			}
 
-			text := resp.Candidates[0].Content.Parts[0]
-			messageChan <- Message{Text: fmt.Sprint(text), Event: "message"}
+			for _, candidate := range resp.Candidates {
+				message := candidateMessage(candidate)
+				if message.Text == "" && message.FinishReason == genai.FinishReasonUnspecified {
+					continue
+				}
+				messageChan <- message
+			}
+
+			resp, err = iter.Next()
 		}
 	}()
 
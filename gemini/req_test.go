@@ -4,16 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/cphovo/ollm/replay"
+	"github.com/cphovo/ollm/util"
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 func TestGemini(t *testing.T) {
+	if os.Getenv("API_KEY") == "" {
+		t.Skip("API_KEY not set")
+	}
 	ctx := context.Background()
 	// Access your API key as an environment variable (see "Set up your API key" above)
 	client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("API_KEY")))
@@ -33,6 +40,9 @@ func TestGemini(t *testing.T) {
 }
 
 func TestGeminiStream(t *testing.T) {
+	if os.Getenv("API_KEY") == "" {
+		t.Skip("API_KEY not set")
+	}
 	ctx := context.Background()
 	// Access your API key as an environment variable (see "Set up your API key" above)
 	client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("API_KEY")))
@@ -63,16 +73,68 @@ func TestGeminiStream(t *testing.T) {
 }
 
 func TestAskStream(t *testing.T) {
-	messageCh, err := AskStream(AskStreamOptions{
-		APIKey: os.Getenv("GEMINI_API_KEY"),
+	session, err := replay.Start("testdata/stream.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Transport = session.Transport(nil)
+	t.Cleanup(func() {
+		Transport = nil
+		if err := session.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	messageCh, err := AskStream(context.Background(), AskStreamOptions{
+		APIKey: util.Ternary(os.Getenv("GEMINI_API_KEY") == "", "test", os.Getenv("GEMINI_API_KEY")),
 		Model:  "gemini-pro",
 		Prompt: "如何使用 go 实现二分查找？",
 	})
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
+	var builder strings.Builder
+	var finishReason genai.FinishReason
 	for message := range messageCh {
-		text := fmt.Sprintf("Text: %s", message.Text)
-		fmt.Println(text)
+		if message.Error != nil {
+			t.Fatal(message.Error)
+		}
+		builder.WriteString(message.Text)
+		if message.FinishReason != genai.FinishReasonUnspecified {
+			finishReason = message.FinishReason
+		}
+	}
+	if builder.String() != "二分查找要求切片有序。" {
+		t.Errorf("text = %q", builder.String())
+	}
+	if finishReason != genai.FinishReasonStop {
+		t.Errorf("finish reason = %v", finishReason)
+	}
+}
+
+func TestIsStreamEnd(t *testing.T) {
+	tests := []struct {
+		body string
+		end  bool
+	}{
+		{`[{"a":1},{"a":2}]`, true},
+		{"[{\"a\":1}\r\n,\r\n{\"a\":2}\r\n]\n", true},
+		// 截断或损坏的响应不是正常结束
+		{`[{"a":1},{"a":]`, false},
+		{`[{"a":1},{"a":{"b":1}]`, false},
+		{`[{"a":1},]`, false},
+	}
+	for _, tt := range tests {
+		tail := &streamTail{}
+		decoder := json.NewDecoder(&tailReader{ReadCloser: io.NopCloser(strings.NewReader(tt.body)), tail: tail})
+		decoder.Token()
+		var err error
+		for err == nil {
+			var raw json.RawMessage
+			err = decoder.Decode(&raw)
+		}
+		if end := err == io.EOF || isStreamEnd(err, tail); end != tt.end {
+			t.Errorf("%q: end = %v (%v), want %v", tt.body, end, err, tt.end)
+		}
 	}
 }
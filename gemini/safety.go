@@ -0,0 +1,101 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cphovo/ollm/util"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// SafetySetting uses the same names as the Gemini REST API, e.g.
+// {"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_NONE"}.
+type SafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+var harmCategories = map[string]genai.HarmCategory{
+	"HARM_CATEGORY_DEROGATORY":        genai.HarmCategoryDerogatory,
+	"HARM_CATEGORY_TOXICITY":          genai.HarmCategoryToxicity,
+	"HARM_CATEGORY_VIOLENCE":          genai.HarmCategoryViolence,
+	"HARM_CATEGORY_SEXUAL":            genai.HarmCategorySexual,
+	"HARM_CATEGORY_MEDICAL":           genai.HarmCategoryMedical,
+	"HARM_CATEGORY_DANGEROUS":         genai.HarmCategoryDangerous,
+	"HARM_CATEGORY_HARASSMENT":        genai.HarmCategoryHarassment,
+	"HARM_CATEGORY_HATE_SPEECH":       genai.HarmCategoryHateSpeech,
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": genai.HarmCategorySexuallyExplicit,
+	"HARM_CATEGORY_DANGEROUS_CONTENT": genai.HarmCategoryDangerousContent,
+}
+
+var harmBlockThresholds = map[string]genai.HarmBlockThreshold{
+	"BLOCK_LOW_AND_ABOVE":    genai.HarmBlockLowAndAbove,
+	"BLOCK_MEDIUM_AND_ABOVE": genai.HarmBlockMediumAndAbove,
+	"BLOCK_ONLY_HIGH":        genai.HarmBlockOnlyHigh,
+	"BLOCK_NONE":             genai.HarmBlockNone,
+}
+
+func (s SafetySetting) toGenAI() (*genai.SafetySetting, error) {
+	name := strings.ToUpper(s.Category)
+	if !strings.HasPrefix(name, "HARM_CATEGORY_") {
+		name = "HARM_CATEGORY_" + name
+	}
+	category, ok := harmCategories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown harm category: %s", s.Category)
+	}
+	threshold, ok := harmBlockThresholds[strings.ToUpper(s.Threshold)]
+	if !ok {
+		return nil, fmt.Errorf("unknown harm block threshold: %s", s.Threshold)
+	}
+	return &genai.SafetySetting{Category: category, Threshold: threshold}, nil
+}
+
+// ResolveSafetySettings merges the configured settings for a model with the
+// settings sent in a request. Later entries override earlier ones of the same
+// category, so request settings win over the model and wildcard ("*") ones.
+func ResolveSafetySettings(config map[string][]SafetySetting, model string, request []SafetySetting) ([]*genai.SafetySetting, error) {
+	var merged []SafetySetting
+	merged = append(merged, config["*"]...)
+	merged = append(merged, config[model]...)
+	merged = append(merged, request...)
+
+	var settings []*genai.SafetySetting
+	index := map[genai.HarmCategory]int{}
+	for _, s := range merged {
+		setting, err := s.toGenAI()
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[setting.Category]; ok {
+			settings[i] = setting
+			continue
+		}
+		index[setting.Category] = len(settings)
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// ReadSafetySettingsFile reads gemini_safety_settings.json, which maps a model
+// name (or "*" for every model) to its safety settings.
+func ReadSafetySettingsFile() (map[string][]SafetySetting, error) {
+	v, err := os.ReadFile(util.WithPath("gemini_safety_settings.json"))
+	if err != nil {
+		return nil, nil
+	}
+	var config map[string][]SafetySetting
+	if err := json.Unmarshal(v, &config); err != nil {
+		return nil, fmt.Errorf("failed to json.Unmarshal content of gemini safety settings file: %w", err)
+	}
+	for model, settings := range config {
+		for _, s := range settings {
+			if _, err := s.toGenAI(); err != nil {
+				return nil, fmt.Errorf("invalid safety setting for %s: %w", model, err)
+			}
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,33 @@
+package gemini
+
+import (
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestResolveSafetySettings(t *testing.T) {
+	config := map[string][]SafetySetting{
+		"*":          {{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"}},
+		"gemini-pro": {{Category: "harassment", Threshold: "block_none"}},
+	}
+	settings, err := ResolveSafetySettings(config, "gemini-pro", []SafetySetting{
+		{Category: "DANGEROUS_CONTENT", Threshold: "BLOCK_NONE"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 2 {
+		t.Fatalf("expected 2 settings, got %d", len(settings))
+	}
+	if settings[0].Category != genai.HarmCategoryDangerousContent || settings[0].Threshold != genai.HarmBlockNone {
+		t.Errorf("request setting should override the wildcard one, got %v", settings[0])
+	}
+	if settings[1].Category != genai.HarmCategoryHarassment || settings[1].Threshold != genai.HarmBlockNone {
+		t.Errorf("unexpected model setting %v", settings[1])
+	}
+
+	if _, err := ResolveSafetySettings(nil, "gemini-pro", []SafetySetting{{Category: "foo", Threshold: "BLOCK_NONE"}}); err == nil {
+		t.Error("expected an error for an unknown category")
+	}
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/audit"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/cphovo/ollm/util"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditLogger is nil when the audit log is disabled
+var AuditLogger *audit.Logger
+
+// gin context keys
+const (
+	APIKeyNameKey = "apiKeyName"
+	ProviderKey   = "provider"
+)
+
+// 流式回答和文件可能很大，超过后只记录元数据
+const maxAuditCapture = 1 << 20
+
+// AuditMiddleware writes one audit record per request with the caller,
+// model, latency, token counts and finish reason.
+func AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AuditLogger == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		var request struct {
+			Model    string                 `json:"model"`
+			Stream   bool                   `json:"stream"`
+			Messages []sydney.OpenAIMessage `json:"messages"`
+			Input    interface{}            `json:"input"`
+			Prompt   string                 `json:"prompt"`
+			Text     string                 `json:"text"`
+		}
+		if c.Request.Method == http.MethodPost && c.ContentType() == gin.MIMEJSON {
+			data, _ := io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewReader(data))
+			json.Unmarshal(data, &request)
+		}
+
+		writer := &capturingWriter{ResponseWriter: c.Writer, limit: maxAuditCapture}
+		c.Writer = writer
+		c.Next()
+
+		record := audit.Record{
+			Time:       start,
+			APIKeyName: c.GetString(APIKeyNameKey),
+			ClientIP:   c.ClientIP(),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Status:     writer.Status(),
+			Provider:   c.GetString(ProviderKey),
+			Model:      request.Model,
+			Stream:     request.Stream,
+			LatencyMs:  time.Since(start).Milliseconds(),
+		}
+
+		var promptBuilder strings.Builder
+		for _, message := range request.Messages {
+			text, _ := sydney.ParseOpenAIMessageContent(message.Content)
+			promptBuilder.WriteString(message.Role + ": " + text + "\n")
+		}
+		if inputs, ok := parseEmbeddingInput(request.Input); ok {
+			promptBuilder.WriteString(strings.Join(inputs, "\n"))
+		}
+		promptBuilder.WriteString(request.Prompt + request.Text)
+		record.Prompt = promptBuilder.String()
+		record.PromptTokens = util.EstimateTokens(record.Prompt)
+
+		// 回答超过 maxAuditCapture 时无法解析，只记录元数据
+		if record.Status != http.StatusOK {
+			var body struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(writer.body.Bytes(), &body)
+			record.Error = util.Ternary(body.Error == "", http.StatusText(record.Status), body.Error)
+		} else if completion := capturedCompletion(writer.body.Bytes(), request.Stream); !writer.truncated && completion != nil && len(completion.Choices) > 0 {
+			choice := completion.Choices[0]
+			record.Response = choice.Message.Content
+			record.CompletionTokens = util.EstimateTokens(choice.Message.Content)
+			record.FinishReason = choice.FinishReason
+			// 上游错误以 `Error: ...` 的形式出现在回答中
+			if strings.HasPrefix(choice.Message.Content, "`Error: ") {
+				record.Error = strings.Trim(strings.TrimPrefix(choice.Message.Content, "`Error: "), "`")
+			}
+		}
+
+		AuditLogger.Log(record)
+	}
+}
+
+func capturedCompletion(body []byte, stream bool) *sydney.OpenAIChatCompletion {
+	if stream {
+		return completionFromStream(body)
+	}
+	var completion sydney.OpenAIChatCompletion
+	if err := json.Unmarshal(body, &completion); err != nil || completion.Object != "chat.completion" {
+		return nil
+	}
+	return &completion
+}
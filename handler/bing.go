@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"strings"
@@ -28,6 +29,12 @@ func BingImageUploadHandler(c *gin.Context) {
 
 	cookies := util.Ternary(uploadReq.Cookies == "", DefaultCookies, util.ParseCookies(uploadReq.Cookies))
 
+	release, ok := acquireUpstream(c, "bing", bingAccount(cookies))
+	if !ok {
+		return
+	}
+	defer release()
+
 	file, err := uploadReq.File.Open()
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to open the file: %v", err)
@@ -41,11 +48,24 @@ func BingImageUploadHandler(c *gin.Context) {
 		return
 	}
 
+	options := sydney.Options{
+		Cookies:       cookies,
+		Proxy:         Proxy,
+		Endpoints:     BingEndpoints,
+		CaptchaSolver: CaptchaSolver,
+	}
+	if err := applyBingLocale(c, BingLocale{}, &options); err != nil {
+		c.String(http.StatusBadRequest, "Bad request: %v", err)
+		return
+	}
+	sydneyAPI, err := sydney.NewSydney(options)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to create sydney: %v", err)
+		return
+	}
+
 	// Upload image
-	imgUrl, err := sydney.NewSydney(sydney.Options{
-		Cookies: cookies,
-		Proxy:   Proxy,
-	}).UploadImage(bytes)
+	imgUrl, err := sydneyAPI.UploadImage(bytes)
 
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Image upload failed: %v", err)
@@ -67,12 +87,31 @@ func BingImageCreateHandler(c *gin.Context) {
 
 	cookies := util.Ternary(request.Cookies == "", DefaultCookies, util.ParseCookies(request.Cookies))
 
-	// Create image
-	image, err := sydney.NewSydney(sydney.Options{
+	release, ok := acquireUpstream(c, "bing", bingAccount(cookies))
+	if !ok {
+		return
+	}
+	defer release()
+
+	options := sydney.Options{
 		Cookies:           cookies,
 		Proxy:             Proxy,
+		Endpoints:         BingEndpoints,
+		CaptchaSolver:     CaptchaSolver,
 		ConversationStyle: "Creative",
-	}).GenerateImage(request.Image)
+	}
+	if err := applyBingLocale(c, BingLocale{}, &options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sydneyAPI, err := sydney.NewSydney(options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating sydney: " + err.Error()})
+		return
+	}
+
+	// Create image
+	image, err := sydneyAPI.GenerateImage(request.Image)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -92,15 +131,50 @@ func BingStreamChatHandler(c *gin.Context) {
 
 	cookies := util.Ternary(request.Cookies == "", DefaultCookies, util.ParseCookies(request.Cookies))
 
-	sydneyAPI := sydney.NewSydney(sydney.Options{
+	plugins, persona, err := bingPlugins("", request.Plugins, request.Persona)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// models 中的 key 对应 conversationStyle，例如 "Precise"
+	optionSetProfile, err := bingOptionSetProfile(request.ConversationStyle, request.OptionSets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	release, ok := acquireUpstream(c, "bing", bingAccount(cookies))
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats := newRequestMetrics("bing", request.ConversationStyle, util.Ternary(request.Cookies == "", "default", ""))
+	defer stats.Done()
+
+	options := sydney.Options{
 		Cookies:           cookies,
 		Proxy:             Proxy,
+		Endpoints:         BingEndpoints,
+		CaptchaSolver:     CaptchaSolver,
 		ConversationStyle: request.ConversationStyle,
 		NoSearch:          request.NoSearch,
 		GPT4Turbo:         request.UseGPT4Turbo,
 		UseClassic:        request.UseClassic,
-		Plugins:           request.Plugins,
-	})
+		Plugins:           plugins,
+		Persona:           persona,
+		OptionSetProfile:  optionSetProfile,
+	}
+	if err := applyBingLocale(c, BingLocale{Location: request.Location, Locale: request.Locale, Market: request.Market}, &options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sydneyAPI, err := sydney.NewSydney(options)
+	if err != nil {
+		stats.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating sydney: " + err.Error()})
+		return
+	}
 
 	// Stream chat
 	messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
@@ -110,16 +184,25 @@ func BingStreamChatHandler(c *gin.Context) {
 		ImageURL:       request.ImageURL,
 	})
 	if err != nil {
+		stats.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
 		return
 	}
 
 	c.Stream(func(w io.Writer) bool {
 		for message := range messageCh {
+			switch message.Type {
+			case sydney.MessageTypeMessageText:
+				stats.Token(message.Text)
+			case sydney.MessageTypeError:
+				stats.ErrorText(message.Text)
+			}
 			encoded, _ := json.Marshal(message.Text)
 			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Type, encoded)
 			c.Writer.Flush()
 		}
+		writeShutdownEvent(c, w)
+		c.Writer.Flush()
 		return false
 	})
 }
@@ -131,8 +214,29 @@ func BingCompleteChatHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	switch request.CitationFormat {
+	case "", sydney.CitationFormatFootnote, sydney.CitationFormatMarkdown:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown citation_format: " + request.CitationFormat})
+		return
+	}
+	plugins, persona, err := bingPlugins(request.Model, request.Plugins, request.Persona)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	optionSetProfile, err := bingOptionSetProfile(request.Model, request.OptionSets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	parsedMessages, err := sydney.ParseOpenAIMessages(request.Messages)
+	recorder, history, ok := openConversation(c, request.ConversationID, "bing", request.Model, request.Messages)
+	if !ok {
+		return
+	}
+
+	parsedMessages, err := sydney.ParseOpenAIMessages(append(history, request.Messages...))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -141,17 +245,44 @@ func BingCompleteChatHandler(c *gin.Context) {
 	cookiesStr := c.GetHeader("Cookie")
 	cookies := util.Ternary(cookiesStr == "", DefaultCookies, util.ParseCookies(cookiesStr))
 
+	release, ok := acquireUpstream(c, "bing", bingAccount(cookies))
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats := newRequestMetrics("bing", request.Model, util.Ternary(cookiesStr == "", "default", ""))
+	defer stats.Done()
+
 	conversationStyle := util.Ternary(
 		strings.HasPrefix(request.Model, "gpt-3.5-turbo"), "Balanced", request.Model)
+	// 插件和 persona 的模型使用 Creative，回答中的 model 仍是请求的模型
+	if _, ok := BingModels[request.Model]; ok {
+		conversationStyle = "Creative"
+	}
 
-	sydneyAPI := sydney.NewSydney(sydney.Options{
+	options := sydney.Options{
 		Cookies:           cookies,
 		Proxy:             Proxy,
+		Endpoints:         BingEndpoints,
+		CaptchaSolver:     CaptchaSolver,
 		ConversationStyle: conversationStyle,
-		Locale:            "en-US",
 		NoSearch:          request.ToolChoice == nil,
 		GPT4Turbo:         true,
-	})
+		Plugins:           plugins,
+		Persona:           persona,
+		OptionSetProfile:  optionSetProfile,
+	}
+	if err := applyBingLocale(c, BingLocale{Location: request.Location, Locale: request.Locale, Market: request.Market}, &options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sydneyAPI, err := sydney.NewSydney(options)
+	if err != nil {
+		stats.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating sydney: " + err.Error()})
+		return
+	}
 
 	messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
 		StopCtx:        c.Request.Context(),
@@ -160,70 +291,189 @@ func BingCompleteChatHandler(c *gin.Context) {
 		ImageURL:       parsedMessages.ImageURL,
 	})
 	if err != nil {
+		stats.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
 		return
 	}
 
+	markdown := request.CitationFormat == sydney.CitationFormatMarkdown
+
 	if !request.Stream {
 		var replyBuilder strings.Builder
 		errored := false
+		var sources []sydney.SourceAttribute
+		var events []sydney.StreamEvent
+		var suggested []string
+		var codeInterpreter codeInterpreterResult
 
 		for message := range messageCh {
 			switch message.Type {
+			case sydney.MessageTypeSearchResult:
+				sources = append(sources, parseSources(message.Text)...)
 			case sydney.MessageTypeMessageText:
+				stats.Token(message.Text)
 				replyBuilder.WriteString(message.Text)
 			case sydney.MessageTypeError:
 				errored = true
+				stats.ErrorText(message.Text)
 				replyBuilder.WriteString("`Error: ")
 				replyBuilder.WriteString(message.Text)
 				replyBuilder.WriteString("`")
+			default:
+				event := sydney.NewStreamEvent(message)
+				if event == nil {
+					continue
+				}
+				if event.Type == sydney.MessageTypeSuggestedResponses {
+					suggested = event.Suggestions
+				}
+				codeInterpreter.add(c.Request.Context(), cookies, event)
+				if request.Events {
+					events = append(events, *event)
+				}
 			}
 		}
 
-		c.JSON(http.StatusOK, sydney.NewOpenAIChatCompletion(
-			conversationStyle,
-			replyBuilder.String(),
+		if !errored && shutdownError(c, stats) {
+			errored = true
+			replyBuilder.WriteString(fmt.Sprintf("`Error: %s`", ErrShutdown))
+		}
+
+		content := replyBuilder.String()
+		if markdown {
+			renderer := sydney.NewCitationRenderer()
+			renderer.AddSources(sources)
+			content = renderer.Write(content) + renderer.Flush()
+		}
+
+		if !errored {
+			recorder.Save(content, "")
+		}
+
+		completion := sydney.NewOpenAIChatCompletion(
+			request.Model,
+			content,
 			util.Ternary(errored, sydney.FinishReasonLength, sydney.FinishReasonStop),
-		))
+		)
+		completion.Choices[0].Message.Annotations = sydney.NewAnnotations(content, sources)
+		completion.Choices[0].Message.Events = events
+		if len(suggested) == 0 && request.Suggestions && !errored {
+			suggested = generateSuggestions(c.Request.Context(), "", parsedMessages.Prompt, content)
+		}
+		completion.Choices[0].Message.SuggestedResponses = suggested
+		completion.Choices[0].Message.CodeInterpreter = codeInterpreter.runs
+		completion.Choices[0].Message.ContentParts = codeInterpreter.contentParts()
+		c.JSON(http.StatusOK, completion)
 
 		return
 	}
 
 	c.Stream(func(w io.Writer) bool {
 		errored := false
+		var replyBuilder strings.Builder
+		var sources []sydney.SourceAttribute
+		var suggested []string
+		var codeInterpreter codeInterpreterResult
+		renderer := sydney.NewCitationRenderer()
+
+		writeDelta := func(delta string, event *sydney.StreamEvent) {
+			chunk := sydney.NewOpenAIChatCompletionChunk(request.Model, delta, nil)
+			chunk.Choices[0].Delta.Event = event
+			encoded, err := json.Marshal(chunk)
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintf(w, "data: %s\n\n", encoded)
+			c.Writer.Flush()
+		}
+		// 输出被 renderer 暂存的不完整脚注
+		flush := func() {
+			if rest := renderer.Flush(); rest != "" {
+				replyBuilder.WriteString(rest)
+				writeDelta(rest, nil)
+			}
+		}
 
 		for message := range messageCh {
 			var delta string
 
 			switch message.Type {
+			case sydney.MessageTypeSearchResult:
+				parsed := parseSources(message.Text)
+				sources = append(sources, parsed...)
+				renderer.AddSources(parsed)
+				continue
 			case sydney.MessageTypeMessageText:
 				delta = message.Text
+				stats.Token(delta)
+				if markdown {
+					delta = renderer.Write(delta)
+				}
+				if delta == "" {
+					continue
+				}
+				replyBuilder.WriteString(delta)
 			case sydney.MessageTypeError:
+				flush()
 				errored = true
+				stats.ErrorText(message.Text)
 				delta = fmt.Sprintf("`Error: %s`", message.Text)
 			default:
+				event := sydney.NewStreamEvent(message)
+				if event == nil {
+					continue
+				}
+				if event.Type == sydney.MessageTypeSuggestedResponses {
+					suggested = event.Suggestions
+				}
+				codeInterpreter.add(c.Request.Context(), cookies, event)
+				// 搜索、代码执行等中间事件，需要在请求中开启 events
+				if request.Events {
+					writeDelta("", event)
+				}
 				continue
 			}
 
-			chunk := sydney.NewOpenAIChatCompletionChunk(conversationStyle, delta, nil)
-			encoded, err := json.Marshal(chunk)
-			if err != nil {
-				continue
-			}
+			writeDelta(delta, nil)
+		}
+		flush()
 
-			fmt.Fprintf(w, "data: %s\n\n", encoded)
-			c.Writer.Flush()
+		if !errored && shutdownError(c, stats) {
+			errored = true
+			writeErrorChunk(w, request.Model, ErrShutdown.Error())
 		}
 
-		chunk := sydney.NewOpenAIChatCompletionChunk(conversationStyle, "", util.Ternary(errored, &sydney.FinishReasonLength, &sydney.FinishReasonStop))
+		chunk := sydney.NewOpenAIChatCompletionChunk(request.Model, "", util.Ternary(errored, &sydney.FinishReasonLength, &sydney.FinishReasonStop))
+		// 引用的位置要等全文结束后才能确定，放在最后一个 chunk 中
+		chunk.Choices[0].Delta.Annotations = sydney.NewAnnotations(replyBuilder.String(), sources)
+		if len(suggested) == 0 && request.Suggestions && !errored {
+			suggested = generateSuggestions(c.Request.Context(), "", parsedMessages.Prompt, replyBuilder.String())
+		}
+		chunk.Choices[0].Delta.SuggestedResponses = suggested
+		chunk.Choices[0].Delta.CodeInterpreter = codeInterpreter.runs
+		chunk.Choices[0].Delta.ContentParts = codeInterpreter.contentParts()
 		encoded, _ := json.Marshal(chunk)
 		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n", encoded)
 		c.Writer.Flush()
 
+		if !errored {
+			recorder.Save(replyBuilder.String(), "")
+		}
+
 		return false
 	})
 }
 
+// parseSources reads the sources of a MessageTypeSearchResult message
+func parseSources(text string) []sydney.SourceAttribute {
+	var sources []sydney.SourceAttribute
+	if err := json.Unmarshal([]byte(text), &sources); err != nil {
+		slog.Warn("Cannot parse search result", "err", err)
+	}
+	return sources
+}
+
 func BingGenerateImageHandler(c *gin.Context) {
 	var request sydney.OpenAIImageGenerationRequest
 
@@ -236,12 +486,28 @@ func BingGenerateImageHandler(c *gin.Context) {
 	cookiesStr := c.GetHeader("Cookie")
 	cookies := util.Ternary(cookiesStr == "", DefaultCookies, util.ParseCookies(cookiesStr))
 
-	sydneyAPI := sydney.NewSydney(sydney.Options{
+	release, ok := acquireUpstream(c, "bing", bingAccount(cookies))
+	if !ok {
+		return
+	}
+	defer release()
+
+	options := sydney.Options{
 		Cookies:           cookies,
 		Proxy:             Proxy,
+		Endpoints:         BingEndpoints,
+		CaptchaSolver:     CaptchaSolver,
 		ConversationStyle: "Creative",
-		Locale:            "en-US",
-	})
+	}
+	if err := applyBingLocale(c, BingLocale{}, &options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sydneyAPI, err := sydney.NewSydney(options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating sydney: " + err.Error()})
+		return
+	}
 
 	// Ask stream with a new context
 	newContext, cancel := context.WithCancel(c.Request.Context())
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cphovo/ollm/mockupstream"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBingCompletionModel(t *testing.T) {
+	chdirTemp(t)
+	gin.SetMode(gin.TestMode)
+
+	upstream := httptest.NewServer(mockupstream.New(mockupstream.Options{}).Handler())
+	defer upstream.Close()
+	BingEndpoints = sydney.Endpoints{
+		ChatHub:            "ws" + strings.TrimPrefix(upstream.URL, "http") + "/sydney/ChatHub",
+		CreateConversation: upstream.URL + "/edgesvc/turing/conversation/create",
+	}
+	defer func() { BingEndpoints = sydney.Endpoints{} }()
+
+	r := gin.New()
+	r.POST("/v1/chat/completions", BingCompleteChatHandler)
+	// c.Stream 需要 CloseNotifier，用真实的 server
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// gpt-3.5-turbo 使用 Balanced，回答中仍然是请求的模型
+	for _, stream := range []bool{false, true} {
+		body := `{"model":"gpt-3.5-turbo","messages":[{"role":"user","content":"hello"}],"stream":` + strconv.FormatBool(stream) + `}`
+		resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var models []string
+		if stream {
+			data, _ := io.ReadAll(resp.Body)
+			for _, line := range strings.Split(string(data), "\n") {
+				if encoded, ok := strings.CutPrefix(line, "data: {"); ok {
+					var chunk sydney.OpenAIChatCompletionChunk
+					json.Unmarshal([]byte("{"+encoded), &chunk)
+					models = append(models, chunk.Model)
+				}
+			}
+		} else {
+			var completion sydney.OpenAIChatCompletion
+			json.NewDecoder(resp.Body).Decode(&completion)
+			models = append(models, completion.Model)
+		}
+		resp.Body.Close()
+
+		if len(models) == 0 {
+			t.Fatalf("stream %v: no response", stream)
+		}
+		for _, model := range models {
+			if model != "gpt-3.5-turbo" {
+				t.Errorf("stream %v: model = %q", stream, model)
+			}
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/cphovo/ollm/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// Cache is shared by the providers, e.g. Kimi access tokens
+var Cache cache.Backend
+
+func CacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, Cache.Stats())
+}
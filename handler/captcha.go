@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cphovo/ollm/sydney"
+	"github.com/cphovo/ollm/util"
+	"github.com/gin-gonic/gin"
+)
+
+type ResolveCaptchaRequest struct {
+	// e.g. "cct=...", only cct is kept
+	Cookies string `json:"cookies"`
+}
+
+// manualCaptchaSolver writes a 404 itself when the manual solver is not enabled
+func manualCaptchaSolver(c *gin.Context) (*sydney.ManualSolver, bool) {
+	solver, ok := CaptchaSolver.(*sydney.ManualSolver)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "manual captcha solver is not enabled"})
+	}
+	return solver, ok
+}
+
+func PendingCaptchasHandler(c *gin.Context) {
+	solver, ok := manualCaptchaSolver(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, solver.Pending())
+}
+
+func ResolveCaptchaHandler(c *gin.Context) {
+	solver, ok := manualCaptchaSolver(c)
+	if !ok {
+		return
+	}
+	var request ResolveCaptchaRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := solver.Resolve(c.Param("id"), util.ParseCookiesFromString(request.Cookies))
+	if errors.Is(err, sydney.ErrCaptchaNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "captcha resolved"})
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/cphovo/ollm/mirror"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/cphovo/ollm/util"
+)
+
+// FileMirror keeps local copies of the code interpreter files, nil disables it
+var FileMirror *mirror.Mirror
+
+// codeInterpreterResult collects the code interpreter runs and files of a Bing answer
+type codeInterpreterResult struct {
+	runs  []sydney.CodeInterpreterRun
+	files []sydney.GeneratedFile
+}
+
+// add records a code interpreter event, a mirrored file gets its local URL in the event
+func (r *codeInterpreterResult) add(ctx context.Context, cookies map[string]string, event *sydney.StreamEvent) {
+	switch event.Type {
+	case sydney.MessageTypeGeneratedCode:
+		r.runs = append(r.runs, sydney.CodeInterpreterRun{Code: event.Code})
+	case sydney.MessageTypeExecutionOutput:
+		// 输出会多次更新，只保留最新的
+		if len(r.runs) == 0 {
+			r.runs = append(r.runs, sydney.CodeInterpreterRun{})
+		}
+		r.runs[len(r.runs)-1].Output = event.Text
+	case sydney.MessageTypeGeneratedFile:
+		if event.File == nil {
+			return
+		}
+		// 只镜像 Bing 的文件，链接文本可以被 prompt 操纵
+		if FileMirror != nil && sydney.IsBingURL(event.File.URL, BingEndpoints.Bing) {
+			header := http.Header{"Cookie": {util.FormatCookieString(cookies)}}
+			if localURL, err := FileMirror.Save(ctx, event.File.URL, header); err != nil {
+				slog.Warn("Cannot mirror code interpreter file", "url", event.File.URL, "err", err)
+			} else {
+				event.File.LocalURL = localURL
+			}
+		}
+		r.files = append(r.files, *event.File)
+	}
+}
+
+// contentParts prefers the local copies, the upstream links expire with the conversation
+func (r *codeInterpreterResult) contentParts() []sydney.ContentPart {
+	parts := sydney.NewContentParts(r.files)
+	for _, part := range parts {
+		if part.ImageURL != nil && part.File.LocalURL != "" {
+			part.ImageURL.URL = part.File.LocalURL
+		}
+	}
+	return parts
+}
@@ -6,14 +6,23 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/cphovo/ollm/gemini"
+	"github.com/cphovo/ollm/kimi"
+	"github.com/cphovo/ollm/sydney"
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	DefaultCookies      map[string]string
-	Proxy               string
-	DefaultRefreshToken string
-	DefaultAPIKey       string
+	DefaultCookies map[string]string
+	Proxy          string
+	// 上游地址，零值使用官方地址
+	BingEndpoints        sydney.Endpoints
+	KimiEndpoints        kimi.Endpoints
+	GeminiEndpoint       string
+	CaptchaSolver        sydney.CaptchaSolver
+	DefaultRefreshToken  string
+	GeminiKeyPool        *gemini.KeyPool
+	GeminiSafetySettings map[string][]gemini.SafetySetting
 )
 
 var HandlerMap = map[string]gin.HandlerFunc{
@@ -25,9 +34,25 @@ var HandlerMap = map[string]gin.HandlerFunc{
 	"gemini-pro":    GeminiCompleteChatHandler,
 }
 
+var EmbeddingHandlerMap = map[string]gin.HandlerFunc{
+	"text-embedding-004":     GeminiEmbeddingsHandler,
+	"embedding-001":          GeminiEmbeddingsHandler,
+	"text-embedding-ada-002": GeminiEmbeddingsHandler,
+}
+
 func ModelBasedDispatcher() gin.HandlerFunc {
+	return modelBasedDispatcher(HandlerMap)
+}
+
+func ModelBasedEmbeddingDispatcher() gin.HandlerFunc {
+	return modelBasedDispatcher(EmbeddingHandlerMap)
+}
+
+func modelBasedDispatcher(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var body OpenAIChatCompletionRequest
+		var body struct {
+			Model string `json:"model"`
+		}
 		data, _ := io.ReadAll(c.Request.Body)
 		// 重置请求体，以便后续的 handler 可以再次读取
 		c.Request.Body = io.NopCloser(bytes.NewReader(data))
@@ -38,7 +63,7 @@ func ModelBasedDispatcher() gin.HandlerFunc {
 			return
 		}
 
-		if handler, exists := HandlerMap[body.Model]; exists {
+		if handler, exists := handlers[body.Model]; exists {
 			handler(c)
 		} else {
 			c.JSON(http.StatusNotFound, gin.H{"error": "model not supported"})
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/cphovo/ollm/conversation"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+// ConversationStore is nil when CONVERSATION_DB is not configured
+var ConversationStore *conversation.Store
+
+type ConversationRequest struct {
+	Title    string `json:"title"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// 各 provider 的 OpenAI 消息类型结构相同，可以互相转换
+type openAIMessageStruct = struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// conversationRecorder 在请求结束后把新消息和回复写入会话
+type conversationRecorder struct {
+	conversation conversation.Conversation
+	messages     []conversation.Message
+}
+
+// openConversation loads the conversation of the caller's API key and returns
+// its history. It writes the error response itself and returns ok=false when
+// the conversation cannot be used. Without a conversation id the recorder is
+// nil, which is safe to use.
+func openConversation[T ~openAIMessageStruct](c *gin.Context, id, provider, model string, messages []T) (recorder *conversationRecorder, history []T, ok bool) {
+	if id == "" {
+		return nil, nil, true
+	}
+	if ConversationStore == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "conversation store is disabled"})
+		return nil, nil, false
+	}
+
+	conv, err := ConversationStore.Get(id, c.GetString(APIKeyNameKey))
+	if errors.Is(err, conversation.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	if conv.Provider != "" && conv.Provider != provider {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "conversation belongs to provider " + conv.Provider})
+		return nil, nil, false
+	}
+	conv.Provider = provider
+	conv.Model = model
+
+	for _, message := range conv.Messages {
+		history = append(history, T{Role: message.Role, Content: message.Content})
+	}
+
+	recorder = &conversationRecorder{conversation: conv}
+	for _, message := range messages {
+		message := openAIMessageStruct(message)
+		text, _ := sydney.ParseOpenAIMessageContent(message.Content)
+		recorder.messages = append(recorder.messages, conversation.Message{
+			Role:    message.Role,
+			Content: text,
+		})
+	}
+	return recorder, history, true
+}
+
+// UpstreamID returns the provider side id saved by an earlier request
+func (r *conversationRecorder) UpstreamID() string {
+	if r == nil {
+		return ""
+	}
+	return r.conversation.UpstreamID
+}
+
+// Save stores the messages of the request together with the reply.
+func (r *conversationRecorder) Save(reply, upstreamID string) {
+	if r == nil {
+		return
+	}
+	messages := append(r.messages, conversation.Message{
+		Role:       sydney.MessageRoleAssistant,
+		Content:    reply,
+		UpstreamID: upstreamID,
+	})
+	if err := ConversationStore.AddMessages(r.conversation.ID, messages...); err != nil {
+		slog.Error("Cannot save conversation messages", "id", r.conversation.ID, "err", err)
+		return
+	}
+	if upstreamID != "" {
+		r.conversation.UpstreamID = upstreamID
+	}
+	if err := ConversationStore.Update(r.conversation); err != nil {
+		slog.Error("Cannot update conversation", "id", r.conversation.ID, "err", err)
+	}
+}
+
+func conversationStoreEnabled(c *gin.Context) bool {
+	if ConversationStore == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "conversation store is disabled"})
+		return false
+	}
+	return true
+}
+
+func conversationError(c *gin.Context, err error) {
+	if errors.Is(err, conversation.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func CreateConversationHandler(c *gin.Context) {
+	if !conversationStoreEnabled(c) {
+		return
+	}
+
+	var request ConversationRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv, err := ConversationStore.Create(conversation.Conversation{
+		Title:    request.Title,
+		Provider: request.Provider,
+		Model:    request.Model,
+		Owner:    c.GetString(APIKeyNameKey),
+	})
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, conv)
+}
+
+func ListConversationsHandler(c *gin.Context) {
+	if !conversationStoreEnabled(c) {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	conversations, err := ConversationStore.List(c.GetString(APIKeyNameKey), limit, offset)
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": conversations})
+}
+
+func GetConversationHandler(c *gin.Context) {
+	if !conversationStoreEnabled(c) {
+		return
+	}
+
+	conv, err := ConversationStore.Get(c.Param("id"), c.GetString(APIKeyNameKey))
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, conv)
+}
+
+func UpdateConversationHandler(c *gin.Context) {
+	if !conversationStoreEnabled(c) {
+		return
+	}
+
+	conv, err := ConversationStore.Get(c.Param("id"), c.GetString(APIKeyNameKey))
+	if err != nil {
+		conversationError(c, err)
+		return
+	}
+
+	var request ConversationRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Title != "" {
+		conv.Title = request.Title
+	}
+	if request.Model != "" {
+		conv.Model = request.Model
+	}
+
+	if err := ConversationStore.Update(conv); err != nil {
+		conversationError(c, err)
+		return
+	}
+	conv.Messages = nil
+	c.JSON(http.StatusOK, conv)
+}
+
+func DeleteConversationHandler(c *gin.Context) {
+	if !conversationStoreEnabled(c) {
+		return
+	}
+
+	if err := ConversationStore.Delete(c.Param("id"), c.GetString(APIKeyNameKey)); err != nil {
+		conversationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "deleted": true})
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cphovo/ollm/conversation"
+	"github.com/gin-gonic/gin"
+)
+
+func TestConversationOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := conversation.Open(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ConversationStore = store
+	defer func() { ConversationStore = nil }()
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(APIKeyNameKey, c.GetHeader("X-Key"))
+	})
+	r.POST("/v1/conversations", CreateConversationHandler)
+	r.GET("/v1/conversations", ListConversationsHandler)
+	r.GET("/v1/conversations/:id", GetConversationHandler)
+	r.PATCH("/v1/conversations/:id", UpdateConversationHandler)
+	r.DELETE("/v1/conversations/:id", DeleteConversationHandler)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/conversations", "alice", `{"title":"a","provider":"kimi"}`)
+	var created conversation.Conversation
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Owner != "alice" {
+		t.Fatalf("create: %s", w.Body.String())
+	}
+	path := "/v1/conversations/" + created.ID
+
+	// 其他 API key 的请求都当作会话不存在
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		if w := do(method, path, "bob", `{"title":"b"}`); w.Code != http.StatusNotFound {
+			t.Errorf("%s by bob: %d", method, w.Code)
+		}
+	}
+	if w := do(http.MethodGet, "/v1/conversations", "bob", ""); !strings.Contains(w.Body.String(), `"data":[]`) {
+		t.Errorf("list by bob: %s", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/v1/conversations", "alice", ""); !strings.Contains(w.Body.String(), created.ID) {
+		t.Errorf("list by alice: %s", w.Body.String())
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(APIKeyNameKey, "bob")
+	if _, _, ok := openConversation(c, created.ID, "kimi", "kimi", []openAIMessageStruct{}); ok {
+		t.Error("bob can chat in the conversation of alice")
+	}
+
+	if w := do(http.MethodGet, path, "alice", ""); w.Code != http.StatusOK {
+		t.Errorf("get by alice: %d", w.Code)
+	}
+	if w := do(http.MethodDelete, path, "alice", ""); w.Code != http.StatusOK {
+		t.Errorf("delete by alice: %d", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+
+	"github.com/cphovo/ollm/gemini"
+	"github.com/cphovo/ollm/util"
+	"github.com/gin-gonic/gin"
+)
+
+type OpenAIEmbeddingRequest struct {
+	Model          string      `json:"model"`
+	Input          interface{} `json:"input"` // string or array of strings
+	EncodingFormat string      `json:"encoding_format"`
+	APIKey         string      `json:"apiKey"` // Gemini
+}
+
+type OpenAIEmbedding struct {
+	Object    string      `json:"object"`
+	Embedding interface{} `json:"embedding"` // []float32, or a base64 string
+	Index     int         `json:"index"`
+}
+
+type OpenAIEmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type OpenAIEmbeddingResponse struct {
+	Object string               `json:"object"`
+	Data   []OpenAIEmbedding    `json:"data"`
+	Model  string               `json:"model"`
+	Usage  OpenAIEmbeddingUsage `json:"usage"`
+}
+
+// OpenAI 的模型名映射到 Gemini 的 embedding 模型
+var geminiEmbeddingModelAlias = map[string]string{
+	"text-embedding-ada-002": "text-embedding-004",
+}
+
+func GeminiEmbeddingsHandler(c *gin.Context) {
+	var request OpenAIEmbeddingRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input, ok := parseEmbeddingInput(request.Input)
+	if !ok || len(input) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "input must be a non-empty string or an array of strings"})
+		return
+	}
+
+	release, ok := acquireGemini(c, request.APIKey)
+	if !ok {
+		return
+	}
+	defer release()
+
+	model := request.Model
+	if alias, ok := geminiEmbeddingModelAlias[model]; ok {
+		model = alias
+	}
+	var embeddings [][]float32
+	err := withGeminiKey(c.Request.Context(), request.APIKey, func(apiKey string) (err error) {
+		embeddings, err = gemini.Embed(c.Request.Context(), gemini.EmbedOptions{
+			APIKey:   apiKey,
+			Model:    model,
+			Input:    input,
+			Endpoint: GeminiEndpoint,
+		})
+		return
+	})
+	if err != nil {
+		c.JSON(geminiErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := OpenAIEmbeddingResponse{
+		Object: "list",
+		Model:  request.Model,
+	}
+	for i, values := range embeddings {
+		response.Data = append(response.Data, OpenAIEmbedding{
+			Object:    "embedding",
+			Embedding: util.Ternary[interface{}](request.EncodingFormat == "base64", encodeEmbedding(values), values),
+			Index:     i,
+		})
+	}
+	for _, text := range input {
+		response.Usage.PromptTokens += util.EstimateTokens(text)
+	}
+	response.Usage.TotalTokens = response.Usage.PromptTokens
+
+	c.JSON(http.StatusOK, response)
+}
+
+func parseEmbeddingInput(input interface{}) ([]string, bool) {
+	switch input := input.(type) {
+	case string:
+		return []string{input}, true
+	case []interface{}:
+		var texts []string
+		for _, v := range input {
+			text, ok := v.(string)
+			if !ok {
+				// token 数组无法转换成 Gemini 的输入
+				return nil, false
+			}
+			texts = append(texts, text)
+		}
+		return texts, true
+	}
+	return nil, false
+}
+
+// encodeEmbedding encodes the vector as little-endian float32, the format
+// OpenAI uses for encoding_format=base64
+func encodeEmbedding(values []float32) string {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, values)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
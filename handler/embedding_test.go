@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseEmbeddingInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+		ok    bool
+	}{
+		{`"hello"`, []string{"hello"}, true},
+		{`["a","b"]`, []string{"a", "b"}, true},
+		// token 数组不支持
+		{`[1,2,3]`, nil, false},
+		{`[[1,2],[3]]`, nil, false},
+		{`["a",1]`, nil, false},
+		{`42`, nil, false},
+		{`null`, nil, false},
+	}
+	for _, tt := range tests {
+		var input interface{}
+		json.Unmarshal([]byte(tt.input), &input)
+		got, ok := parseEmbeddingInput(input)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEncodeEmbedding(t *testing.T) {
+	values := []float32{1, -0.5, 0.25}
+	decoded, err := base64.StdEncoding.DecodeString(encodeEmbedding(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]float32, len(values))
+	if err := binary.Read(bytes.NewReader(decoded), binary.LittleEndian, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("decoded = %v", got)
+	}
+}
+
+func TestGeminiEmbeddingsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 假的 Gemini 按输入顺序返回 [序号, 文本长度]
+	var paths []string
+	var batches []int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Requests []struct {
+				Content struct {
+					Parts []struct {
+						Text string `json:"text"`
+					} `json:"parts"`
+				} `json:"content"`
+			} `json:"requests"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		paths = append(paths, r.URL.Path)
+		batches = append(batches, len(request.Requests))
+		var embeddings []gin.H
+		for _, req := range request.Requests {
+			text := req.Content.Parts[0].Text
+			embeddings = append(embeddings, gin.H{"values": []float32{float32(len(embeddings)), float32(len(text))}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gin.H{"embeddings": embeddings})
+	}))
+	defer upstream.Close()
+	GeminiEndpoint = upstream.URL
+	defer func() { GeminiEndpoint = "" }()
+
+	r := gin.New()
+	r.POST("/v1/embeddings", GeminiEmbeddingsHandler)
+	post := func(body string) (int, OpenAIEmbeddingResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body)))
+		var response OpenAIEmbeddingResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	t.Run("string", func(t *testing.T) {
+		paths, batches = nil, nil
+		code, response := post(`{"model":"text-embedding-004","input":"hello","apiKey":"k"}`)
+		if code != http.StatusOK || len(response.Data) != 1 || response.Model != "text-embedding-004" {
+			t.Fatalf("%d %+v", code, response)
+		}
+		if got := response.Data[0].Embedding; !reflect.DeepEqual(got, []interface{}{0.0, 5.0}) {
+			t.Errorf("embedding = %v", got)
+		}
+		if response.Usage.PromptTokens == 0 || response.Usage.TotalTokens != response.Usage.PromptTokens {
+			t.Errorf("usage = %+v", response.Usage)
+		}
+	})
+
+	t.Run("batches of 100", func(t *testing.T) {
+		paths, batches = nil, nil
+		input, _ := json.Marshal(strings.Split(strings.Repeat("a,", 150)+"a", ","))
+		code, response := post(`{"model":"text-embedding-004","apiKey":"k","input":` + string(input) + `}`)
+		if code != http.StatusOK || len(response.Data) != 151 {
+			t.Fatalf("%d, %d embeddings", code, len(response.Data))
+		}
+		if !reflect.DeepEqual(batches, []int{100, 51}) {
+			t.Errorf("batches = %v", batches)
+		}
+		for i, data := range response.Data {
+			if data.Index != i {
+				t.Errorf("data %d has index %d", i, data.Index)
+			}
+		}
+	})
+
+	t.Run("ada-002 alias", func(t *testing.T) {
+		paths, batches = nil, nil
+		code, response := post(`{"model":"text-embedding-ada-002","input":["a"],"apiKey":"k"}`)
+		if code != http.StatusOK || response.Model != "text-embedding-ada-002" {
+			t.Fatalf("%d %+v", code, response)
+		}
+		if len(paths) != 1 || !strings.Contains(paths[0], "models/text-embedding-004:") {
+			t.Errorf("paths = %v", paths)
+		}
+	})
+
+	t.Run("base64", func(t *testing.T) {
+		code, response := post(`{"model":"text-embedding-004","input":"abc","encoding_format":"base64","apiKey":"k"}`)
+		if code != http.StatusOK || len(response.Data) != 1 {
+			t.Fatalf("%d %+v", code, response)
+		}
+		if got := response.Data[0].Embedding; got != encodeEmbedding([]float32{0, 3}) {
+			t.Errorf("embedding = %v", got)
+		}
+	})
+
+	t.Run("token arrays", func(t *testing.T) {
+		paths, batches = nil, nil
+		for _, input := range []string{`[1,2,3]`, `[[1,2]]`, `[]`} {
+			if code, _ := post(`{"model":"text-embedding-004","apiKey":"k","input":` + input + `}`); code != http.StatusBadRequest {
+				t.Errorf("%s: %d", input, code)
+			}
+		}
+		if len(paths) != 0 {
+			t.Errorf("upstream called for invalid input: %v", paths)
+		}
+	})
+}
@@ -1,16 +1,21 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/cphovo/ollm/gemini"
+	"github.com/cphovo/ollm/limiter"
 	"github.com/cphovo/ollm/sydney"
 	"github.com/cphovo/ollm/util"
 	"github.com/gin-gonic/gin"
+	"github.com/google/generative-ai-go/genai"
 )
 
 type GeminiChatStreamRequest struct {
@@ -24,12 +29,27 @@ type GeminiOpenAIMessage struct {
 	Content interface{} `json:"content"`
 }
 
+type GeminiResponseFormat struct {
+	Type string `json:"type"`
+}
+
 type GeminiOpenAIChatCompletionRequest struct {
-	Model      string                `json:"model"`
-	Messages   []GeminiOpenAIMessage `json:"messages"`
-	Stream     bool                  `json:"stream"`
-	ToolChoice *interface{}          `json:"tool_choice"`
-	APIKey     string                `json:"refreshToken"`
+	Model          string                 `json:"model"`
+	Messages       []GeminiOpenAIMessage  `json:"messages"`
+	Stream         bool                   `json:"stream"`
+	ToolChoice     *interface{}           `json:"tool_choice"`
+	APIKey         string                 `json:"apiKey"`
+	Temperature    *float32               `json:"temperature"`
+	TopP           *float32               `json:"top_p"`
+	MaxTokens      *int32                 `json:"max_tokens"`
+	Stop           interface{}            `json:"stop"` // string or array of strings
+	N              *int32                 `json:"n"`
+	ResponseFormat *GeminiResponseFormat  `json:"response_format"`
+	Seed           *int64                 `json:"seed"`
+	SafetySettings []gemini.SafetySetting `json:"safety_settings"`
+	ConversationID string                 `json:"conversation_id"`
+	// Generate suggested responses with SuggestionsModel
+	Suggestions bool `json:"suggestions"`
 }
 
 func GeminiStreamChatHandler(c *gin.Context) {
@@ -46,23 +66,45 @@ func GeminiStreamChatHandler(c *gin.Context) {
 	}
 
 	model := util.Ternary(request.Model == "", "gemini-pro", request.Model)
-	apiKey := util.Ternary(request.APIKey == "", DefaultAPIKey, request.APIKey)
 
-	messageCh, err := gemini.AskStream(gemini.AskStreamOptions{
-		APIKey: apiKey,
-		Model:  model,
-		Prompt: request.Text,
+	release, ok := acquireGemini(c, request.APIKey)
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats := newRequestMetrics("gemini", model, util.Ternary(request.APIKey == "", "pool", ""))
+	defer stats.Done()
+
+	var messageCh <-chan gemini.Message
+	err := withGeminiKey(c.Request.Context(), request.APIKey, func(apiKey string) (err error) {
+		messageCh, err = gemini.AskStream(c.Request.Context(), gemini.AskStreamOptions{
+			APIKey:   apiKey,
+			Model:    model,
+			Prompt:   request.Text,
+			Endpoint: GeminiEndpoint,
+		})
+		return
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
+		stats.Error(err)
+		c.JSON(geminiErrorStatus(err), gin.H{"error": "error creating conversation: " + err.Error()})
 		return
 	}
 	c.Stream(func(w io.Writer) bool {
 		for message := range messageCh {
+			switch message.Event {
+			case "message":
+				stats.Token(message.Text)
+			case "error":
+				stats.ErrorText(message.Text)
+			}
 			encoded, _ := json.Marshal(message.Text)
 			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Event, encoded)
 			c.Writer.Flush()
 		}
+		writeShutdownEvent(c, w)
+		c.Writer.Flush()
 		return false
 	})
 }
@@ -81,39 +123,108 @@ func GeminiCompleteChatHandler(c *gin.Context) {
 	}
 
 	model := util.Ternary(request.Model == "", "gemini", request.Model)
-	apiKey := util.Ternary(request.APIKey == "", DefaultAPIKey, request.APIKey)
+
+	release, ok := acquireGemini(c, request.APIKey)
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats := newRequestMetrics("gemini", model, util.Ternary(request.APIKey == "", "pool", ""))
+	defer stats.Done()
+
+	safetySettings, err := gemini.ResolveSafetySettings(GeminiSafetySettings, model, request.SafetySettings)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Seed != nil {
+		slog.Warn("Gemini does not support seed, ignored", "seed", *request.Seed)
+	}
+
+	recorder, history, ok := openConversation(c, request.ConversationID, "gemini", model, request.Messages)
+	if !ok {
+		return
+	}
 
 	// 将 OpenAI 格式的消息转换成 Kimi 格式的消息
-	text := geminiMessagesPrepare(request.Messages)
+	text := geminiMessagesPrepare(append(history, request.Messages...))
 	options := gemini.AskStreamOptions{
-		APIKey: apiKey,
-		Model:  model,
-		Prompt: text,
+		Model:           model,
+		Prompt:          text,
+		Endpoint:        GeminiEndpoint,
+		Temperature:     request.Temperature,
+		TopP:            request.TopP,
+		MaxOutputTokens: request.MaxTokens,
+		StopSequences:   parseStopSequences(request.Stop),
+		CandidateCount:  request.N,
+		SafetySettings:  safetySettings,
+	}
+	if request.ResponseFormat != nil && request.ResponseFormat.Type == "json_object" {
+		options.ResponseMIMEType = "application/json"
 	}
 
 	if !request.Stream {
-		reply, err := gemini.Ask(options)
-		errored := false
+		var choices []gemini.Choice
+		err := withGeminiKey(c.Request.Context(), request.APIKey, func(apiKey string) (err error) {
+			options.APIKey = apiKey
+			choices, err = gemini.Ask(c.Request.Context(), options)
+			return
+		})
 		if err != nil {
-			reply = err.Error()
-			errored = true
+			stats.Error(err)
+		}
+		if err != nil && geminiErrorStatus(err) != http.StatusInternalServerError {
+			c.JSON(geminiErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
-		c.JSON(http.StatusOK, sydney.NewOpenAIChatCompletion(
-			strings.ToUpper(model),
-			reply,
-			util.Ternary(errored, sydney.FinishReasonLength, sydney.FinishReasonStop),
-		))
+		completion := sydney.NewOpenAIChatCompletion(strings.ToUpper(model), "", sydney.FinishReasonStop)
+		if err != nil {
+			completion.Choices[0].Message.Content = err.Error()
+			completion.Choices[0].FinishReason = sydney.FinishReasonLength
+		} else {
+			if len(choices) > 0 {
+				recorder.Save(choices[0].Text, "")
+			}
+			completion.Choices = nil
+			for _, choice := range choices {
+				stats.Token(choice.Text)
+				completion.Choices = append(completion.Choices, sydney.ChatCompletionChoice{
+					Index: choice.Index,
+					Message: sydney.ChoiceMessage{
+						Role:    "assistant",
+						Content: choice.Text,
+					},
+					FinishReason: geminiFinishReason(choice.FinishReason),
+				})
+			}
+			if request.Suggestions && len(choices) > 0 {
+				completion.Choices[0].Message.SuggestedResponses = generateSuggestions(c.Request.Context(), request.APIKey, text, choices[0].Text)
+			}
+		}
+		c.JSON(http.StatusOK, completion)
 		return
 	}
 
-	messageCh, err := gemini.AskStream(options)
+	var messageCh <-chan gemini.Message
+	err = withGeminiKey(c.Request.Context(), request.APIKey, func(apiKey string) (err error) {
+		options.APIKey = apiKey
+		messageCh, err = gemini.AskStream(c.Request.Context(), options)
+		return
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
+		stats.Error(err)
+		c.JSON(geminiErrorStatus(err), gin.H{"error": "error creating conversation: " + err.Error()})
 		return
 	}
 
 	c.Stream(func(w io.Writer) bool {
 		errored := false
+		var replyBuilder strings.Builder
+		// 每个 candidate 对应一个 choice，记录各自的结束原因
+		finishReasons := map[int]string{}
+		candidateCount := 1
 
 		for message := range messageCh {
 			var delta string
@@ -121,14 +232,28 @@ func GeminiCompleteChatHandler(c *gin.Context) {
 			switch message.Event {
 			case "message":
 				delta = message.Text
+				stats.Token(delta)
+				// 会话只记录第一个 candidate
+				if message.Index == 0 {
+					replyBuilder.WriteString(delta)
+				}
+				candidateCount = max(candidateCount, message.Index+1)
+				if message.FinishReason != genai.FinishReasonUnspecified {
+					finishReasons[message.Index] = geminiFinishReason(message.FinishReason)
+				}
+				if delta == "" {
+					continue
+				}
 			case "error":
 				errored = true
+				stats.ErrorText(message.Text)
 				delta = fmt.Sprintf("`Error: %s`", message.Text)
 			default:
 				continue
 			}
 
 			chunk := sydney.NewOpenAIChatCompletionChunk(strings.ToUpper(model), delta, nil)
+			chunk.Choices[0].Index = message.Index
 			encoded, err := json.Marshal(chunk)
 			if err != nil {
 				continue
@@ -138,15 +263,119 @@ func GeminiCompleteChatHandler(c *gin.Context) {
 			c.Writer.Flush()
 		}
 
-		chunk := sydney.NewOpenAIChatCompletionChunk(strings.ToUpper(model), "", util.Ternary(errored, &sydney.FinishReasonLength, &sydney.FinishReasonStop))
-		encoded, _ := json.Marshal(chunk)
-		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n", encoded)
+		if !errored && shutdownError(c, stats) {
+			errored = true
+			writeErrorChunk(w, strings.ToUpper(model), ErrShutdown.Error())
+		}
+
+		for i := 0; i < candidateCount; i++ {
+			finishReason, ok := finishReasons[i]
+			if !ok || errored {
+				finishReason = util.Ternary(errored, sydney.FinishReasonLength, sydney.FinishReasonStop)
+			}
+			chunk := sydney.NewOpenAIChatCompletionChunk(strings.ToUpper(model), "", &finishReason)
+			chunk.Choices[0].Index = i
+			// 建议回复只针对第一个 candidate
+			if i == 0 && request.Suggestions && !errored {
+				chunk.Choices[0].Delta.SuggestedResponses = generateSuggestions(c.Request.Context(), request.APIKey, text, replyBuilder.String())
+			}
+			encoded, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", encoded)
+		}
+		fmt.Fprintf(w, "data: [DONE]\n")
 		c.Writer.Flush()
 
+		if !errored {
+			recorder.Save(replyBuilder.String(), "")
+		}
+
 		return false
 	})
 }
 
+// withGeminiKey 优先使用请求中的 API Key，否则从 key 池中选择，配额用尽时自动切换到下一个 key。
+// 池中的 key 各自受 gemini limiter 限制，选中的 key 一直占用到 ctx 结束。
+func withGeminiKey(ctx context.Context, apiKey string, fn func(apiKey string) error) error {
+	if apiKey != "" {
+		return fn(apiKey)
+	}
+	return GeminiKeyPool.Do(func(key string) error {
+		l := UpstreamLimiters["gemini"]
+		if l == nil {
+			return fn(key)
+		}
+		release, err := l.Acquire(ctx, accountKey(key))
+		if err != nil {
+			return err
+		}
+		// 流式请求在 fn 返回后仍在使用 key
+		stop := context.AfterFunc(ctx, release)
+		if err := fn(key); err != nil {
+			if stop() {
+				release()
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// acquireGemini limits the requests bringing their own API key, the keys of
+// the pool are limited by withGeminiKey.
+func acquireGemini(c *gin.Context, apiKey string) (release func(), ok bool) {
+	if apiKey == "" {
+		c.Set(ProviderKey, "gemini")
+		return func() {}, true
+	}
+	return acquireUpstream(c, "gemini", apiKey)
+}
+
+func geminiErrorStatus(err error) int {
+	var blocked *gemini.PromptBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		return http.StatusBadRequest
+	case errors.Is(err, gemini.ErrNoAvailableKey), gemini.IsQuotaError(err),
+		errors.Is(err, limiter.ErrQueueFull), errors.Is(err, limiter.ErrQueueTimeout):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func GeminiKeyPoolHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": GeminiKeyPool.Status()})
+}
+
+// geminiFinishReason translates a Gemini finish reason into the OpenAI one
+func geminiFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonMaxTokens:
+		return sydney.FinishReasonLength
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return sydney.FinishReasonContentFilter
+	default:
+		return sydney.FinishReasonStop
+	}
+}
+
+// parseStopSequences accepts the OpenAI stop field, which is either a string or an array of strings
+func parseStopSequences(stop interface{}) []string {
+	switch stop := stop.(type) {
+	case string:
+		return []string{stop}
+	case []interface{}:
+		var sequences []string
+		for _, v := range stop {
+			if s, ok := v.(string); ok {
+				sequences = append(sequences, s)
+			}
+		}
+		return sequences
+	}
+	return nil
+}
+
 // TODO: 暂时和 KIMI 一样，后续修改
 func geminiMessagesPrepare(messages []GeminiOpenAIMessage) string {
 	var contentBuilder strings.Builder
@@ -27,12 +27,15 @@ type KimiOpenAIMessage struct {
 }
 
 type KimiOpenAIChatCompletionRequest struct {
-	Model        string              `json:"model"`
-	Messages     []KimiOpenAIMessage `json:"messages"`
-	Stream       bool                `json:"stream"`
-	ToolChoice   *interface{}        `json:"tool_choice"`
-	RefreshToken string              `json:"refreshToken"`
-	UseSearch    *bool               `json:"useSearch"`
+	Model          string              `json:"model"`
+	Messages       []KimiOpenAIMessage `json:"messages"`
+	Stream         bool                `json:"stream"`
+	ToolChoice     *interface{}        `json:"tool_choice"`
+	RefreshToken   string              `json:"refreshToken"`
+	UseSearch      *bool               `json:"useSearch"`
+	ConversationID string              `json:"conversation_id"`
+	// Generate suggested responses with SuggestionsModel
+	Suggestions bool `json:"suggestions"`
 }
 
 func KimiStreamChatHandler(c *gin.Context) {
@@ -50,34 +53,52 @@ func KimiStreamChatHandler(c *gin.Context) {
 
 	refreshToken := util.Ternary(request.RefreshToken == "", DefaultRefreshToken, request.RefreshToken)
 
-	kimiAI, err := kimi.NewKimi(refreshToken)
+	release, ok := acquireUpstream(c, "kimi", refreshToken)
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats := newRequestMetrics("kimi", "kimi", util.Ternary(request.RefreshToken == "", "default", ""))
+	defer stats.Done()
+
+	kimiAI, err := kimi.NewKimi(c.Request.Context(), refreshToken, KimiEndpoints)
 	if err != nil {
+		stats.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// new conversation
 	if request.ConvId == "" {
-		convId, err := kimiAI.CreateChat("未命名会话")
+		convId, err := kimiAI.CreateChat(c.Request.Context(), "未命名会话")
 		if err != nil {
+			stats.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
 			return
 		}
 		request.ConvId = convId
 	}
 
-	messageCh, err := kimiAI.AskStream(kimi.AskStreamOptions{
+	messageCh, err := kimiAI.AskStream(c.Request.Context(), kimi.AskStreamOptions{
 		Text:      request.Text,
 		ConvId:    request.ConvId,
 		UseSearch: request.UseSearch,
 	})
 	if err != nil {
+		stats.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
 		return
 	}
 
 	c.Stream(func(w io.Writer) bool {
 		for message := range messageCh {
+			switch message.Event {
+			case "cmpl":
+				stats.Token(message.Text)
+			case "error":
+				stats.ErrorText(message.Text)
+			}
 			encoded, _ := json.Marshal(message.Text)
 			// 将 cmpl 类型转换成 message 类型消息，方便和 bing 统一
 			if message.Event == "cmpl" {
@@ -87,6 +108,7 @@ func KimiStreamChatHandler(c *gin.Context) {
 			}
 			c.Writer.Flush()
 		}
+		writeShutdownEvent(c, w)
 
 		// 最后追加一个 conv_id 事件
 		encoded, _ := json.Marshal(request.ConvId)
@@ -111,30 +133,52 @@ func KimiCompleteChatHandler(c *gin.Context) {
 	}
 
 	refreshToken := util.Ternary(request.RefreshToken == "", DefaultRefreshToken, request.RefreshToken)
+
+	release, ok := acquireUpstream(c, "kimi", refreshToken)
+	if !ok {
+		return
+	}
+	defer release()
+
+	stats := newRequestMetrics("kimi", request.Model, util.Ternary(request.RefreshToken == "", "default", ""))
+	defer stats.Done()
 	useSearch := util.Ternary(request.UseSearch != nil, *request.UseSearch, true)
 
-	kimiAI, err := kimi.NewKimi(refreshToken)
+	kimiAI, err := kimi.NewKimi(c.Request.Context(), refreshToken, KimiEndpoints)
 	if err != nil {
+		stats.Error(err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 没次创建一个新聊天
-	convId, err := kimiAI.CreateChat("未命名会话")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
+	recorder, history, ok := openConversation(c, request.ConversationID, "kimi", request.Model, request.Messages)
+	if !ok {
 		return
 	}
 
+	// 会话已有对应的 Kimi 聊天时只发送新消息，否则每次创建一个新聊天并带上历史消息
+	convId := recorder.UpstreamID()
+	messages := request.Messages
+	if convId == "" {
+		convId, err = kimiAI.CreateChat(c.Request.Context(), "未命名会话")
+		if err != nil {
+			stats.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
+			return
+		}
+		messages = append(history, messages...)
+	}
+
 	// 将 OpenAI 格式的消息转换成 Kimi 格式的消息
-	text := messagesPrepare(request.Messages)
+	text := messagesPrepare(messages)
 
-	messageCh, err := kimiAI.AskStream(kimi.AskStreamOptions{
+	messageCh, err := kimiAI.AskStream(c.Request.Context(), kimi.AskStreamOptions{
 		Text:      text,
 		ConvId:    convId,
 		UseSearch: useSearch,
 	})
 	if err != nil {
+		stats.Error(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating conversation: " + err.Error()})
 		return
 	}
@@ -147,25 +191,42 @@ func KimiCompleteChatHandler(c *gin.Context) {
 			// TODO
 			switch message.Event {
 			case "cmpl":
+				stats.Token(message.Text)
 				replyBuilder.WriteString(message.Text)
 			case "error":
 				errored = true
+				stats.ErrorText(message.Text)
 				replyBuilder.WriteString("`Error: ")
 				replyBuilder.WriteString(message.Text)
 				replyBuilder.WriteString("`")
 			}
 		}
-		c.JSON(http.StatusOK, sydney.NewOpenAIChatCompletion(
+
+		if !errored && shutdownError(c, stats) {
+			errored = true
+			replyBuilder.WriteString(fmt.Sprintf("`Error: %s`", ErrShutdown))
+		}
+
+		if !errored {
+			recorder.Save(replyBuilder.String(), convId)
+		}
+
+		completion := sydney.NewOpenAIChatCompletion(
 			"KIMI",
 			replyBuilder.String(),
 			util.Ternary(errored, sydney.FinishReasonLength, sydney.FinishReasonStop),
-		))
+		)
+		if request.Suggestions && !errored {
+			completion.Choices[0].Message.SuggestedResponses = generateSuggestions(c.Request.Context(), "", text, replyBuilder.String())
+		}
+		c.JSON(http.StatusOK, completion)
 
 		return
 	}
 
 	c.Stream(func(w io.Writer) bool {
 		errored := false
+		var replyBuilder strings.Builder
 
 		for message := range messageCh {
 			var delta string
@@ -173,8 +234,11 @@ func KimiCompleteChatHandler(c *gin.Context) {
 			switch message.Event {
 			case "cmpl":
 				delta = message.Text
+				stats.Token(delta)
+				replyBuilder.WriteString(delta)
 			case "error":
 				errored = true
+				stats.ErrorText(message.Text)
 				delta = fmt.Sprintf("`Error: %s`", message.Text)
 			default:
 				continue
@@ -190,11 +254,23 @@ func KimiCompleteChatHandler(c *gin.Context) {
 			c.Writer.Flush()
 		}
 
+		if !errored && shutdownError(c, stats) {
+			errored = true
+			writeErrorChunk(w, "KIMI", ErrShutdown.Error())
+		}
+
 		chunk := sydney.NewOpenAIChatCompletionChunk("KIMI", "", util.Ternary(errored, &sydney.FinishReasonLength, &sydney.FinishReasonStop))
+		if request.Suggestions && !errored {
+			chunk.Choices[0].Delta.SuggestedResponses = generateSuggestions(c.Request.Context(), "", text, replyBuilder.String())
+		}
 		encoded, _ := json.Marshal(chunk)
 		fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n", encoded)
 		c.Writer.Flush()
 
+		if !errored {
+			recorder.Save(replyBuilder.String(), convId)
+		}
+
 		return false
 	})
 }
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/cphovo/ollm/limiter"
+	"github.com/gin-gonic/gin"
+)
+
+// UpstreamLimiters 按 provider 配置，没有配置的 provider 不限制
+var UpstreamLimiters = map[string]*limiter.Limiter{}
+
+// accountKey 不在状态接口中暴露 cookie 或 token 原文
+func accountKey(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:6])
+}
+
+// bingAccount identifies a Bing account by its _U cookie, or by the whole cookie set.
+func bingAccount(cookies map[string]string) string {
+	if u := cookies["_U"]; u != "" {
+		return u
+	}
+	names := make([]string, 0, len(cookies))
+	for name := range cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var credential string
+	for _, name := range names {
+		credential += name + "=" + cookies[name] + ";"
+	}
+	return credential
+}
+
+// acquireUpstream waits for the provider limiter of the account. It writes a
+// 429 itself and returns ok=false when the request cannot be sent.
+func acquireUpstream(c *gin.Context, provider, credential string) (release func(), ok bool) {
+	c.Set(ProviderKey, provider)
+	l := UpstreamLimiters[provider]
+	if l == nil {
+		return func() {}, true
+	}
+
+	release, err := l.Acquire(c.Request.Context(), accountKey(credential))
+	if errors.Is(err, limiter.ErrQueueFull) || errors.Is(err, limiter.ErrQueueTimeout) {
+		c.Header("Retry-After", strconv.Itoa(1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": provider + ": " + err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		// 客户端已断开
+		c.Abort()
+		return nil, false
+	}
+	return release, true
+}
+
+func UpstreamLimitersHandler(c *gin.Context) {
+	status := gin.H{}
+	for provider, l := range UpstreamLimiters {
+		status[provider] = l.Status()
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+// BingLocale selects the location preset, locale and market of Bing requests,
+// empty fields fall back to the next level.
+type BingLocale struct {
+	Location string `json:"location"`
+	Locale   string `json:"locale"`
+	Market   string `json:"market"`
+}
+
+type BingLocaleConfig struct {
+	Default BingLocale `json:"default"`
+	// API key name -> locale
+	Keys map[string]BingLocale `json:"keys"`
+	// Extra locations, they replace the built-in presets of the same name
+	Presets map[string]sydney.Location `json:"presets"`
+}
+
+// BingLocales 由 bing_locations.json 和 BING_LOCATION 等环境变量配置
+var BingLocales BingLocaleConfig
+
+// 请求头，优先级低于请求体中的字段
+const (
+	BingLocationHeader = "X-Bing-Location"
+	BingLocaleHeader   = "X-Bing-Locale"
+	BingMarketHeader   = "X-Bing-Market"
+)
+
+func (l BingLocale) or(fallback BingLocale) BingLocale {
+	if l.Location == "" {
+		l.Location = fallback.Location
+	}
+	if l.Locale == "" {
+		l.Locale = fallback.Locale
+	}
+	if l.Market == "" {
+		l.Market = fallback.Market
+	}
+	return l
+}
+
+// requestBingLocale merges the request fields, the headers, the API key and the default config
+func requestBingLocale(c *gin.Context, requested BingLocale) BingLocale {
+	headers := BingLocale{
+		Location: c.GetHeader(BingLocationHeader),
+		Locale:   c.GetHeader(BingLocaleHeader),
+		Market:   c.GetHeader(BingMarketHeader),
+	}
+	return requested.or(headers).or(BingLocales.Keys[c.GetString(APIKeyNameKey)]).or(BingLocales.Default)
+}
+
+// applyBingLocale sets the location, locale and market of the sydney options.
+// It returns an error for unknown locations.
+func applyBingLocale(c *gin.Context, requested BingLocale, options *sydney.Options) error {
+	locale := requestBingLocale(c, requested)
+	if locale.Location != "" {
+		name := strings.ToLower(locale.Location)
+		location, ok := BingLocales.Presets[name]
+		if !ok {
+			location, ok = sydney.LocationPresets[name]
+		}
+		if !ok {
+			return fmt.Errorf("unknown location: %s", locale.Location)
+		}
+		options.Location = location
+	}
+	if locale.Locale != "" {
+		options.Locale = locale.Locale
+	}
+	if locale.Market != "" {
+		options.Market = locale.Market
+	}
+	return nil
+}
+
+// bingLocaleCacheKey is added to the response cache key, the request fields are already part of it
+func bingLocaleCacheKey(c *gin.Context) string {
+	locale := requestBingLocale(c, BingLocale{})
+	if locale == BingLocales.Default {
+		return ""
+	}
+	return locale.Location + "|" + locale.Locale + "|" + locale.Market
+}
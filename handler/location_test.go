@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+func TestApplyBingLocale(t *testing.T) {
+	BingLocales = BingLocaleConfig{
+		Default: BingLocale{Location: "london", Locale: "en-GB"},
+		Keys:    map[string]BingLocale{"alice": {Location: "office", Locale: "zh-CN"}},
+		Presets: map[string]sydney.Location{"office": {Name: "Office", Region: "CN"}},
+	}
+	defer func() { BingLocales = BingLocaleConfig{} }()
+
+	tests := []struct {
+		name      string
+		key       string
+		headers   map[string]string
+		requested BingLocale
+		location  string
+		locale    string
+		market    string
+		err       bool
+	}{
+		{name: "default", location: "London, England", locale: "en-GB"},
+		{name: "api key", key: "alice", location: "Office", locale: "zh-CN"},
+		{name: "header", key: "alice", headers: map[string]string{BingLocationHeader: "Tokyo", BingMarketHeader: "ja-JP"},
+			location: "Tokyo, Tokyo", locale: "zh-CN", market: "ja-JP"},
+		{name: "request wins", headers: map[string]string{BingLocationHeader: "tokyo"},
+			requested: BingLocale{Location: "berlin", Locale: "de-DE"}, location: "Berlin, Berlin", locale: "de-DE"},
+		{name: "unknown", requested: BingLocale{Location: "atlantis"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+			for k, v := range tt.headers {
+				c.Request.Header.Set(k, v)
+			}
+			if tt.key != "" {
+				c.Set(APIKeyNameKey, tt.key)
+			}
+			var options sydney.Options
+			err := applyBingLocale(c, tt.requested, &options)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if options.Location.Name != tt.location || options.Locale != tt.locale || options.Market != tt.market {
+				t.Errorf("got location %q, locale %q, market %q", options.Location.Name, options.Locale, options.Market)
+			}
+		})
+	}
+}
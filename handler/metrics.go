@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/gemini"
+	"github.com/cphovo/ollm/metrics"
+	"github.com/cphovo/ollm/util"
+)
+
+// requestMetrics records the metrics of one chat completion request
+type requestMetrics struct {
+	provider string
+	model    string
+	account  string
+	start    time.Time
+	gotToken bool
+	errType  string
+}
+
+// newRequestMetrics starts the metrics of a request. account names the
+// configured account used, it is empty for the credentials of the client,
+// whose health is not recorded.
+func newRequestMetrics(provider, model, account string) *requestMetrics {
+	return &requestMetrics{
+		provider: provider,
+		model:    metricsModel(model),
+		account:  account,
+		start:    time.Now(),
+	}
+}
+
+// 在 init 中设置，直接引用 HandlerMap 会形成初始化循环
+var isRegisteredModel func(model string) bool
+
+func init() {
+	isRegisteredModel = func(model string) bool {
+		_, ok := HandlerMap[model]
+		return ok
+	}
+}
+
+// metricsModel 只把注册过的模型作为标签，避免客户端传入任意字符串
+func metricsModel(model string) string {
+	if isRegisteredModel(model) {
+		return model
+	}
+	return "other"
+}
+
+// Token counts a piece of the answer, the first one also records the time to first token.
+func (m *requestMetrics) Token(delta string) {
+	if delta == "" {
+		return
+	}
+	if !m.gotToken {
+		m.gotToken = true
+		metrics.TimeToFirstToken.WithLabelValues(m.provider, m.model).Observe(time.Since(m.start).Seconds())
+	}
+	metrics.Tokens.WithLabelValues(m.provider, m.model).Add(float64(util.EstimateTokens(delta)))
+}
+
+func (m *requestMetrics) Error(err error) {
+	m.errType = errorType(err)
+}
+
+func (m *requestMetrics) ErrorText(text string) {
+	m.Error(errors.New(text))
+}
+
+func (m *requestMetrics) Done() {
+	status := "ok"
+	if m.errType != "" {
+		status = "error"
+		metrics.Errors.WithLabelValues(m.provider, m.model, m.errType).Inc()
+	}
+	metrics.Requests.WithLabelValues(m.provider, m.model, status).Inc()
+	metrics.RequestDuration.WithLabelValues(m.provider, m.model).Observe(time.Since(m.start).Seconds())
+
+	if m.account == "" {
+		return
+	}
+	switch m.errType {
+	case "":
+		metrics.AccountHealth.WithLabelValues(m.provider, m.account).Set(1)
+	case "captcha", "throttled", "unauthorized", "quota":
+		metrics.AccountHealth.WithLabelValues(m.provider, m.account).Set(0)
+	}
+}
+
+// errorType 把上游的错误归类，作为 metrics 的标签
+func errorType(err error) string {
+	var blocked *gemini.PromptBlockedError
+	text := err.Error()
+	switch {
+	case strings.Contains(text, "CAPTCHA"):
+		return "captcha"
+	case strings.Contains(text, "Throttled"), strings.Contains(text, "status code: 429"):
+		return "throttled"
+	case errors.Is(err, gemini.ErrNoAvailableKey), gemini.IsQuotaError(err):
+		return "quota"
+	case strings.Contains(text, "status code: 401"), strings.Contains(text, "status code: 403"):
+		return "unauthorized"
+	case strings.Contains(text, "revoke"):
+		return "revoked"
+	case strings.Contains(text, "Bing filter"), errors.As(err, &blocked):
+		return "filtered"
+	default:
+		return "upstream"
+	}
+}
@@ -0,0 +1,16 @@
+package handler
+
+import "testing"
+
+func TestMetricsModel(t *testing.T) {
+	for model, want := range map[string]string{
+		"Creative":      "Creative",
+		"kimi":          "kimi",
+		"":              "other",
+		"anything-else": "other",
+	} {
+		if got := metricsModel(model); got != want {
+			t.Errorf("metricsModel(%q) = %q, want %q", model, got, want)
+		}
+	}
+}
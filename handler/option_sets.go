@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+// bingOptionSetProfile picks the profile of the request, then the one of the model.
+// Empty means the default profile.
+func bingOptionSetProfile(model, requested string) (string, error) {
+	name := requested
+	if name == "" {
+		name = sydney.CurrentOptionSetProfiles().Models[model]
+	}
+	if name != "" && !sydney.HasOptionSetProfile(name) {
+		return "", fmt.Errorf("unknown option set profile: %s", name)
+	}
+	return name, nil
+}
+
+func OptionSetProfilesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, sydney.CurrentOptionSetProfiles())
+}
+
+// ReloadOptionSetProfilesHandler reads bing_option_sets.json again, the
+// current profiles are kept when the file is invalid.
+func ReloadOptionSetProfilesHandler(c *gin.Context) {
+	profiles, err := sydney.ReadOptionSetProfilesFile()
+	if err == nil {
+		err = sydney.SetOptionSetProfiles(profiles)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	slog.Info("Option set profiles reloaded", "profiles", len(profiles.Profiles))
+	c.JSON(http.StatusOK, profiles)
+}
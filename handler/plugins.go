@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cphovo/ollm/sydney"
+)
+
+// BingModel is a model id served by Bing with a plugin or a persona
+type BingModel struct {
+	Plugins []string
+	Persona string
+}
+
+// BingModels 由 bing_plugins.json 中带 model 的插件和 persona 生成
+var BingModels = map[string]BingModel{}
+
+// RegisterBingModels exposes the plugins and personas that have a model id.
+// It returns an error when the id is already taken.
+func RegisterBingModels(registry sydney.Registry) error {
+	register := func(model string, bingModel BingModel) error {
+		if model == "" {
+			return nil
+		}
+		if _, ok := HandlerMap[model]; ok {
+			return fmt.Errorf("model %s is already registered", model)
+		}
+		HandlerMap[model] = BingCompleteChatHandler
+		BingModels[model] = bingModel
+		return nil
+	}
+	for _, plugin := range registry.Plugins {
+		if err := register(plugin.Model, BingModel{Plugins: []string{plugin.Name}}); err != nil {
+			return err
+		}
+	}
+	for _, persona := range registry.Personas {
+		if err := register(persona.Model, BingModel{Persona: persona.Name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bingPlugins merges the plugins and persona of the model with the ones of the request
+func bingPlugins(model string, plugins []string, persona string) ([]string, string, error) {
+	if bingModel, ok := BingModels[model]; ok {
+		plugins = append(append([]string{}, bingModel.Plugins...), plugins...)
+		if persona == "" {
+			persona = bingModel.Persona
+		}
+	}
+	// 插件名不区分大小写，重复的只保留第一个
+	var merged []string
+	for _, name := range plugins {
+		if _, ok := sydney.FindPlugin(name); !ok {
+			return nil, "", fmt.Errorf("unknown plugin: %s", name)
+		}
+		if !slices.ContainsFunc(merged, func(s string) bool { return strings.EqualFold(s, name) }) {
+			merged = append(merged, name)
+		}
+	}
+	plugins = merged
+	if _, ok := sydney.FindPersona(persona); persona != "" && !ok {
+		return nil, "", fmt.Errorf("unknown persona: %s", persona)
+	}
+	return plugins, persona, nil
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cphovo/ollm/sydney"
+)
+
+func TestRegisterBingModels(t *testing.T) {
+	registry := sydney.Registry{
+		Plugins:  []sydney.Plugin{{Name: "Suno", Model: "bing-suno"}},
+		Personas: []sydney.Persona{{Name: "Designer", GptID: "designer", Model: "bing-designer"}},
+	}
+	defer func() {
+		delete(HandlerMap, "bing-suno")
+		delete(HandlerMap, "bing-designer")
+		BingModels = map[string]BingModel{}
+	}()
+	if err := RegisterBingModels(registry); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := HandlerMap["bing-suno"]; !ok {
+		t.Error("bing-suno is not registered")
+	}
+	if err := RegisterBingModels(sydney.Registry{Plugins: []sydney.Plugin{{Name: "Suno", Model: "kimi"}}}); err == nil {
+		t.Error("expected an error for a taken model id")
+	}
+
+	plugins, persona, err := bingPlugins("bing-suno", []string{"suno"}, "")
+	if err != nil || !reflect.DeepEqual(plugins, []string{"Suno"}) || persona != "" {
+		t.Errorf("unexpected plugins %v, persona %q, err %v", plugins, persona, err)
+	}
+	if _, persona, _ := bingPlugins("bing-designer", nil, ""); persona != "Designer" {
+		t.Errorf("expected the Designer persona, got %q", persona)
+	}
+	if _, _, err := bingPlugins("Creative", []string{"unknown"}, ""); err == nil {
+		t.Error("expected an error for an unknown plugin")
+	}
+	if _, _, err := bingPlugins("Creative", nil, "unknown"); err == nil {
+		t.Error("expected an error for an unknown persona")
+	}
+}
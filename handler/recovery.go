@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns a panic in a handler into an OpenAI style 500 error instead
+// of killing the process. The stack is logged by gin, broken pipes are only logged.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		err, ok := recovered.(error)
+		if !ok {
+			err = fmt.Errorf("%v", recovered)
+		}
+		slog.Error("Recovered from panic", "path", c.Request.URL.Path, "err", err)
+
+		body := gin.H{"error": gin.H{
+			"message": "internal server error: " + err.Error(),
+			"type":    "server_error",
+			"param":   nil,
+			"code":    nil,
+		}}
+		if c.Writer.Written() {
+			// 流已经开始，只能追加一个错误事件
+			encoded, _ := json.Marshal(body)
+			fmt.Fprintf(c.Writer, "data: %s\n\n", encoded)
+			c.Writer.Flush()
+			c.Abort()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, body)
+	})
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/cache"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ResponseCache is nil when the response cache is disabled
+	ResponseCache    cache.Backend
+	ResponseCacheTTL time.Duration
+)
+
+const (
+	ResponseCacheHeader       = "X-Ollm-Cache"
+	ResponseCacheBypassHeader = "X-Ollm-Cache-Bypass"
+)
+
+// 超过这个大小的回答不缓存
+const maxResponseCacheCapture = 1 << 20
+
+// 不影响回答内容的字段，不参与缓存 key 的计算。apiKey 和 refreshToken 参与计算，
+// 回答不能给使用其他上游凭据的调用方
+var responseCacheIgnoredFields = []string{"stream", "user"}
+
+// responseCacheKey hashes the normalized request body. encoding/json sorts
+// map keys, so the same request always gives the same key regardless of the
+// field order sent by the client. Only the hash is stored, not the credentials.
+func responseCacheKey(body map[string]interface{}) string {
+	normalized := map[string]interface{}{}
+	for k, v := range body {
+		if !slices.Contains(responseCacheIgnoredFields, k) {
+			normalized[k] = v
+		}
+	}
+	encoded, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(encoded)
+	return "response:" + hex.EncodeToString(sum[:])
+}
+
+// ResponseCacheMiddleware answers repeated chat completion requests from the
+// cache. Only completions that finished with "stop" are cached, and a cached
+// answer is replayed as SSE when the request asks for a stream.
+func ResponseCacheMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ResponseCache == nil {
+			c.Next()
+			return
+		}
+
+		data, _ := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			c.Next()
+			return
+		}
+		// 会话请求每次的历史都不同，并且需要写入回复，不能缓存
+		if c.GetHeader(ResponseCacheBypassHeader) != "" || body["conversation_id"] != nil {
+			c.Header(ResponseCacheHeader, "BYPASS")
+			c.Next()
+			return
+		}
+
+		// 请求头和 API key 选择的 Bing 位置也会影响回答
+		if locale := bingLocaleCacheKey(c); locale != "" {
+			body["_bingLocale"] = locale
+		}
+		// 每个 API key 和每组 Bing cookies 分开缓存
+		body["_apiKeyName"] = c.GetString(APIKeyNameKey)
+		if cookie := c.GetHeader("Cookie"); cookie != "" {
+			body["_cookie"] = cookie
+		}
+		key := responseCacheKey(body)
+		stream, _ := body["stream"].(bool)
+
+		var completion sydney.OpenAIChatCompletion
+		ok, err := cache.GetJSON(ResponseCache, key, &completion)
+		if err != nil {
+			slog.Warn("Cannot read response cache", "err", err)
+		}
+		if ok {
+			c.Header(ResponseCacheHeader, "HIT")
+			completion.Created = time.Now().Unix()
+			if stream {
+				replayCompletion(c, &completion)
+			} else {
+				c.JSON(http.StatusOK, completion)
+			}
+			c.Abort()
+			return
+		}
+
+		c.Header(ResponseCacheHeader, "MISS")
+		writer := &capturingWriter{ResponseWriter: c.Writer, limit: maxResponseCacheCapture}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() != http.StatusOK || writer.truncated {
+			return
+		}
+		var captured *sydney.OpenAIChatCompletion
+		if stream {
+			captured = completionFromStream(writer.body.Bytes())
+		} else if err := json.Unmarshal(writer.body.Bytes(), &completion); err == nil {
+			captured = &completion
+		}
+		if !cacheableCompletion(captured) {
+			return
+		}
+		if err := cache.SetJSON(ResponseCache, key, captured, ResponseCacheTTL); err != nil {
+			slog.Warn("Cannot save response cache", "err", err)
+		}
+	}
+}
+
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	// Captures at most limit bytes when set, truncated reports the rest was dropped
+	limit     int
+	truncated bool
+}
+
+func (w *capturingWriter) capture(data []byte) {
+	if w.limit > 0 && w.body.Len()+len(data) > w.limit {
+		w.truncated = true
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// 只缓存正常结束的回答，错误和被截断的回答下次需要重新请求
+func cacheableCompletion(completion *sydney.OpenAIChatCompletion) bool {
+	if completion == nil || len(completion.Choices) == 0 {
+		return false
+	}
+	for _, choice := range completion.Choices {
+		if choice.FinishReason != sydney.FinishReasonStop {
+			return false
+		}
+	}
+	return true
+}
+
+// completionFromStream joins the SSE chunks of a streamed answer into one completion.
+func completionFromStream(data []byte) *sydney.OpenAIChatCompletion {
+	var completion *sydney.OpenAIChatCompletion
+	var builders []*strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || line == "[DONE]" {
+			continue
+		}
+		var chunk sydney.OpenAIChatCompletionChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil
+		}
+		if completion == nil {
+			completion = sydney.NewOpenAIChatCompletion(chunk.Model, "", "")
+			completion.Choices = nil
+		}
+		for _, choice := range chunk.Choices {
+			for len(completion.Choices) <= choice.Index {
+				completion.Choices = append(completion.Choices, sydney.ChatCompletionChoice{
+					Index:   len(completion.Choices),
+					Message: sydney.ChoiceMessage{Role: sydney.MessageRoleAssistant},
+				})
+				builders = append(builders, &strings.Builder{})
+			}
+			builders[choice.Index].WriteString(choice.Delta.Content)
+			completion.Choices[choice.Index].Message.Annotations = append(completion.Choices[choice.Index].Message.Annotations, choice.Delta.Annotations...)
+			completion.Choices[choice.Index].Message.CodeInterpreter = append(completion.Choices[choice.Index].Message.CodeInterpreter, choice.Delta.CodeInterpreter...)
+			completion.Choices[choice.Index].Message.ContentParts = append(completion.Choices[choice.Index].Message.ContentParts, choice.Delta.ContentParts...)
+			if len(choice.Delta.SuggestedResponses) > 0 {
+				completion.Choices[choice.Index].Message.SuggestedResponses = choice.Delta.SuggestedResponses
+			}
+			if choice.Delta.Event != nil {
+				completion.Choices[choice.Index].Message.Events = append(completion.Choices[choice.Index].Message.Events, *choice.Delta.Event)
+			}
+			if choice.FinishReason != nil {
+				completion.Choices[choice.Index].FinishReason = *choice.FinishReason
+			}
+		}
+	}
+	if completion == nil {
+		return nil
+	}
+	for i, builder := range builders {
+		completion.Choices[i].Message.Content = builder.String()
+	}
+	return completion
+}
+
+// replayCompletion sends a cached completion as a synthetic SSE stream.
+func replayCompletion(c *gin.Context, completion *sydney.OpenAIChatCompletion) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Status(http.StatusOK)
+
+	for _, choice := range completion.Choices {
+		for _, event := range choice.Message.Events {
+			chunk := sydney.NewOpenAIChatCompletionChunk(completion.Model, "", nil)
+			chunk.Choices[0].Index = choice.Index
+			chunk.Choices[0].Delta.Event = &event
+			encoded, _ := json.Marshal(chunk)
+			fmt.Fprintf(c.Writer, "data: %s\n\n", encoded)
+		}
+
+		chunk := sydney.NewOpenAIChatCompletionChunk(completion.Model, choice.Message.Content, nil)
+		chunk.Choices[0].Index = choice.Index
+		encoded, _ := json.Marshal(chunk)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", encoded)
+
+		finishReason := choice.FinishReason
+		chunk = sydney.NewOpenAIChatCompletionChunk(completion.Model, "", &finishReason)
+		chunk.Choices[0].Index = choice.Index
+		chunk.Choices[0].Delta.Annotations = choice.Message.Annotations
+		chunk.Choices[0].Delta.SuggestedResponses = choice.Message.SuggestedResponses
+		chunk.Choices[0].Delta.CodeInterpreter = choice.Message.CodeInterpreter
+		chunk.Choices[0].Delta.ContentParts = choice.Message.ContentParts
+		encoded, _ = json.Marshal(chunk)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", encoded)
+	}
+	fmt.Fprintf(c.Writer, "data: [DONE]\n")
+	c.Writer.Flush()
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cphovo/ollm/cache"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+func TestResponseCacheMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ResponseCache = cache.NewMemory(0, 0)
+	ResponseCacheTTL = time.Minute
+	defer func() { ResponseCache = nil }()
+
+	calls := 0
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(APIKeyNameKey, c.GetHeader("X-Key"))
+	})
+	r.POST("/", ResponseCacheMiddleware(), func(c *gin.Context) {
+		calls++
+		var request struct {
+			Stream bool `json:"stream"`
+			Large  bool `json:"large"`
+		}
+		c.BindJSON(&request)
+		if request.Large {
+			c.JSON(http.StatusOK, sydney.NewOpenAIChatCompletion("KIMI", strings.Repeat("a", maxResponseCacheCapture), sydney.FinishReasonStop))
+			return
+		}
+		if !request.Stream {
+			c.JSON(http.StatusOK, sydney.NewOpenAIChatCompletion("KIMI", "hello world", sydney.FinishReasonStop))
+			return
+		}
+		c.Stream(func(w io.Writer) bool {
+			for _, delta := range []string{"hello", " world"} {
+				encoded, _ := json.Marshal(sydney.NewOpenAIChatCompletionChunk("KIMI", delta, nil))
+				fmt.Fprintf(w, "data: %s\n\n", encoded)
+			}
+			encoded, _ := json.Marshal(sydney.NewOpenAIChatCompletionChunk("KIMI", "", &sydney.FinishReasonStop))
+			fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n", encoded)
+			return false
+		})
+	})
+
+	// c.Stream 需要 CloseNotifier，httptest.ResponseRecorder 不支持，用真实的 server
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	post := func(body string, headers ...string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	// 流式请求的回答同样可以被非流式请求命中，字段顺序不影响 key
+	resp, body := post(`{"model":"kimi","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if got := resp.Header.Get(ResponseCacheHeader); got != "MISS" {
+		t.Fatalf("first request: %s", got)
+	}
+	resp, body = post(`{"messages":[{"role":"user","content":"hi"}],"model":"kimi"}`)
+	if got := resp.Header.Get(ResponseCacheHeader); got != "HIT" || calls != 1 {
+		t.Fatalf("second request: %s, calls %d", got, calls)
+	}
+	var completion sydney.OpenAIChatCompletion
+	json.Unmarshal([]byte(body), &completion)
+	if completion.Choices[0].Message.Content != "hello world" {
+		t.Fatalf("cached content %q", completion.Choices[0].Message.Content)
+	}
+
+	resp, body = post(`{"model":"kimi","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if got := resp.Header.Get(ResponseCacheHeader); got != "HIT" || !strings.Contains(body, "data: [DONE]") {
+		t.Fatalf("stream replay: %s %s", got, body)
+	}
+	if replayed := completionFromStream([]byte(body)); replayed.Choices[0].Message.Content != "hello world" {
+		t.Fatalf("replayed content %q", replayed.Choices[0].Message.Content)
+	}
+
+	resp, body = post(`{"model":"kimi","messages":[{"role":"user","content":"hi"}]}`, ResponseCacheBypassHeader, "1")
+	if got := resp.Header.Get(ResponseCacheHeader); got != "BYPASS" || calls != 2 {
+		t.Fatalf("bypass: %s, calls %d", got, calls)
+	}
+
+	// 其他 API key、上游凭据或 Bing cookies 不能命中别人的回答
+	same := `{"model":"kimi","messages":[{"role":"user","content":"tenant"}]}`
+	for i, headers := range [][]string{
+		{"X-Key", "alice"},
+		{"X-Key", "bob"},
+		{"X-Key", "bob", "Cookie", "_U=other"},
+	} {
+		if resp, _ := post(same, headers...); resp.Header.Get(ResponseCacheHeader) != "MISS" {
+			t.Fatalf("key %d: %s", i, resp.Header.Get(ResponseCacheHeader))
+		}
+	}
+	for i, body := range []string{
+		`{"model":"kimi","refreshToken":"a","messages":[{"role":"user","content":"tenant"}]}`,
+		`{"model":"kimi","refreshToken":"b","messages":[{"role":"user","content":"tenant"}]}`,
+	} {
+		if resp, _ := post(body, "X-Key", "alice"); resp.Header.Get(ResponseCacheHeader) != "MISS" {
+			t.Fatalf("credential %d: %s", i, resp.Header.Get(ResponseCacheHeader))
+		}
+	}
+	if resp, _ := post(same, "X-Key", "alice"); resp.Header.Get(ResponseCacheHeader) != "HIT" {
+		t.Fatalf("same key: %s", resp.Header.Get(ResponseCacheHeader))
+	}
+
+	// 超过捕获上限的回答不缓存
+	for i := 0; i < 2; i++ {
+		resp, body := post(`{"model":"kimi","large":true,"messages":[{"role":"user","content":"hi"}]}`)
+		if got := resp.Header.Get(ResponseCacheHeader); got != "MISS" || len(body) <= maxResponseCacheCapture {
+			t.Fatalf("large %d: %s, %d bytes", i, got, len(body))
+		}
+	}
+}
+
+func TestCapturingWriterLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var writer *capturingWriter
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		writer = &capturingWriter{ResponseWriter: c.Writer, limit: 8}
+		c.Writer = writer
+		c.String(http.StatusOK, "12345")
+		c.Writer.WriteString("67890")
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// 客户端收到完整的回答，只有捕获被截断
+	if w.Body.String() != "1234567890" {
+		t.Errorf("response = %q", w.Body.String())
+	}
+	if writer.body.String() != "12345" || !writer.truncated {
+		t.Errorf("captured = %q, truncated = %v", writer.body.String(), writer.truncated)
+	}
+}
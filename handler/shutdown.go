@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrShutdown is the cause of the requests still running when the shutdown deadline expires
+var ErrShutdown = errors.New("server is shutting down, please retry")
+
+// interrupted reports whether the request was cancelled by the shutdown
+func interrupted(c *gin.Context) bool {
+	return errors.Is(context.Cause(c.Request.Context()), ErrShutdown)
+}
+
+// shutdownError records the shutdown as the error of an interrupted request
+func shutdownError(c *gin.Context, stats *requestMetrics) bool {
+	if !interrupted(c) {
+		return false
+	}
+	stats.Error(ErrShutdown)
+	return true
+}
+
+// writeErrorChunk writes an error as the last content chunk of an OpenAI stream
+func writeErrorChunk(w io.Writer, model, text string) {
+	chunk := sydney.NewOpenAIChatCompletionChunk(model, fmt.Sprintf("`Error: %s`", text), nil)
+	encoded, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", encoded)
+}
+
+// writeShutdownEvent ends the /chat/stream style endpoints with an error event
+func writeShutdownEvent(c *gin.Context, w io.Writer) {
+	if !interrupted(c) {
+		return
+	}
+	encoded, _ := json.Marshal(ErrShutdown.Error())
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", "error", encoded)
+}
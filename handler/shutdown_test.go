@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cphovo/ollm/mockupstream"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/gin-gonic/gin"
+)
+
+// chdirTemp runs the test in a temporary directory, so the cookies.json
+// written by the Bing client does not end up in the package
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestShutdownDrain(t *testing.T) {
+	chdirTemp(t)
+	gin.SetMode(gin.TestMode)
+
+	// 回答足够长，关闭时流一定还没有结束
+	upstream := httptest.NewServer(mockupstream.New(mockupstream.Options{Reply: strings.Repeat("word ", 1000)}).Handler())
+	defer upstream.Close()
+	BingEndpoints = sydney.Endpoints{
+		ChatHub:            "ws" + strings.TrimPrefix(upstream.URL, "http") + "/sydney/ChatHub",
+		CreateConversation: upstream.URL + "/edgesvc/turing/conversation/create",
+	}
+	defer func() { BingEndpoints = sydney.Endpoints{} }()
+
+	r := gin.New()
+	r.POST("/chat/stream", BingStreamChatHandler)
+	r.POST("/v1/chat/completions", BingCompleteChatHandler)
+
+	tests := []struct {
+		path  string
+		body  string
+		check func(t *testing.T, rest string)
+	}{
+		{
+			path: "/chat/stream",
+			body: `{"prompt":"hello","conversationStyle":"Creative"}`,
+			check: func(t *testing.T, rest string) {
+				encoded, _ := json.Marshal(ErrShutdown.Error())
+				if !strings.HasSuffix(rest, "event: error\ndata: "+string(encoded)+"\n\n") {
+					t.Errorf("stream does not end with the shutdown event: %q", tail(rest))
+				}
+			},
+		},
+		{
+			path: "/v1/chat/completions",
+			body: `{"model":"Creative","stream":true,"messages":[{"role":"user","content":"hello"}]}`,
+			check: func(t *testing.T, rest string) {
+				if !strings.HasSuffix(rest, "data: [DONE]\n") {
+					t.Fatalf("stream does not end with [DONE]: %q", tail(rest))
+				}
+				var chunks []sydney.OpenAIChatCompletionChunk
+				for _, line := range strings.Split(rest, "\n") {
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok || data == "[DONE]" {
+						continue
+					}
+					var chunk sydney.OpenAIChatCompletionChunk
+					if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+						t.Fatal(err)
+					}
+					chunks = append(chunks, chunk)
+				}
+				if len(chunks) < 2 {
+					t.Fatalf("expected an error chunk and a terminal chunk, got %d chunks", len(chunks))
+				}
+				errorChunk, last := chunks[len(chunks)-2], chunks[len(chunks)-1]
+				if !strings.Contains(errorChunk.Choices[0].Delta.Content, ErrShutdown.Error()) {
+					t.Errorf("error chunk = %+v", errorChunk.Choices[0].Delta)
+				}
+				if reason := last.Choices[0].FinishReason; reason == nil || *reason != sydney.FinishReasonLength {
+					t.Errorf("finish reason = %v", reason)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// 和 main.go 一样，请求的 context 派生自 baseCtx
+			baseCtx, cancelRequests := context.WithCancelCause(context.Background())
+			defer cancelRequests(nil)
+			server := httptest.NewUnstartedServer(r)
+			server.Config.BaseContext = func(net.Listener) context.Context { return baseCtx }
+			server.Start()
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			reader := bufio.NewReader(resp.Body)
+			// 收到第一段回答后模拟关闭超时
+			if _, err := reader.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+			cancelRequests(ErrShutdown)
+			rest, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, string(rest))
+		})
+	}
+}
+
+func tail(s string) string {
+	return s[max(0, len(s)-300):]
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/gemini"
+)
+
+// SuggestionsModel is the Gemini model that writes the suggested responses of
+// providers without them, empty disables it.
+var SuggestionsModel string
+
+const suggestionsPrompt = `Below is a conversation between a user and an assistant.
+Suggest 3 short follow-up messages the user is likely to send next, in the language of the user.
+Reply with a JSON array of strings only.
+
+# Conversation
+%s
+
+# Last answer
+%s`
+
+// 对话只保留结尾的部分，降低次要请求的开销
+const (
+	suggestionsMaxPrompt = 4000
+	maxSuggestions       = 3
+)
+
+// generateSuggestions asks Gemini for suggested responses with the request
+// key or the key pool. The keys of the pool go through the gemini limiter like
+// any other request, the request key is already held by the Gemini handler.
+// Errors are only logged, the answer is already done.
+func generateSuggestions(ctx context.Context, apiKey, prompt, answer string) []string {
+	if SuggestionsModel == "" || strings.TrimSpace(answer) == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	if runes := []rune(prompt); len(runes) > suggestionsMaxPrompt {
+		prompt = string(runes[len(runes)-suggestionsMaxPrompt:])
+	}
+	maxTokens := int32(256)
+	options := gemini.AskStreamOptions{
+		Model:            SuggestionsModel,
+		Prompt:           fmt.Sprintf(suggestionsPrompt, prompt, answer),
+		Endpoint:         GeminiEndpoint,
+		MaxOutputTokens:  &maxTokens,
+		ResponseMIMEType: "application/json",
+	}
+
+	var choices []gemini.Choice
+	err := withGeminiKey(ctx, apiKey, func(apiKey string) (err error) {
+		options.APIKey = apiKey
+		choices, err = gemini.Ask(ctx, options)
+		return
+	})
+	if err != nil {
+		slog.Warn("Cannot generate suggested responses", "model", SuggestionsModel, "err", err)
+		return nil
+	}
+	if len(choices) == 0 {
+		return nil
+	}
+	return parseSuggestions(choices[0].Text)
+}
+
+// parseSuggestions reads the JSON array of the model, a ```json fence is allowed
+func parseSuggestions(text string) []string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.Trim(text, "`\n ")
+
+	var suggestions []string
+	if err := json.Unmarshal([]byte(text), &suggestions); err != nil {
+		slog.Warn("Cannot parse suggested responses", "text", text, "err", err)
+		return nil
+	}
+	var res []string
+	for _, s := range suggestions {
+		if s = strings.TrimSpace(s); s != "" && len(res) < maxSuggestions {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSuggestions(t *testing.T) {
+	cases := map[string][]string{
+		`["a", "b"]`:                          {"a", "b"},
+		"```json\n[\"a\", \" \", \"b\"]\n```": {"a", "b"},
+		`["1", "2", "3", "4"]`:                {"1", "2", "3"},
+		`not json`:                            nil,
+	}
+	for text, expected := range cases {
+		if got := parseSuggestions(text); !reflect.DeepEqual(got, expected) {
+			t.Errorf("parseSuggestions(%q) = %v, expected %v", text, got, expected)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package kimi
+
+import (
+	"strings"
+
+	"github.com/cphovo/ollm/util"
+)
+
+const defaultBaseURL = "https://kimi.moonshot.cn"
+
+// Endpoints are the Kimi APIs a client talks to. Empty fields are derived
+// from Base, which defaults to the official site.
+type Endpoints struct {
+	Base         string `json:"base"`
+	RefreshToken string `json:"refreshToken"`
+	CreateChat   string `json:"createChat"`
+	// %s is replaced with the chat id
+	CompletionStream string `json:"completionStream"`
+	User             string `json:"user"`
+	UserStatus       string `json:"userStatus"`
+	ChatList         string `json:"chatList"`
+}
+
+func (e Endpoints) withDefaults() Endpoints {
+	base := strings.TrimSuffix(util.Ternary(e.Base == "", defaultBaseURL, e.Base), "/")
+	or := func(v, path string) string {
+		return util.Ternary(v == "", base+path, v)
+	}
+	return Endpoints{
+		Base:             base,
+		RefreshToken:     or(e.RefreshToken, "/api/auth/token/refresh"),
+		CreateChat:       or(e.CreateChat, "/api/chat"),
+		CompletionStream: or(e.CompletionStream, "/api/chat/%s/completion/stream"),
+		User:             or(e.User, "/api/user"),
+		UserStatus:       or(e.UserStatus, "/api/chat_1m/user/status"),
+		ChatList:         or(e.ChatList, "/api/chat/list"),
+	}
+}
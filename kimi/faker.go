@@ -8,33 +8,28 @@ import (
 	"time"
 )
 
-const (
-	userUrl       = "https://kimi.moonshot.cn/api/user"
-	userStatusUrl = "https://kimi.moonshot.cn/api/chat_1m/user/status"
-	chatListUrl   = "https://kimi.moonshot.cn/api/chat/list"
-)
-
 // 模拟用户的一些请求，正常使用 Kimi 官网时，时不时的会触发以下 3 种请求
-func FakeUserRequest(accessToken string) error {
-	client := &http.Client{}
+func FakeUserRequest(accessToken string, endpoints Endpoints) error {
+	endpoints = endpoints.withDefaults()
+	client := &http.Client{Transport: Transport}
 
 	requests := []func() (*http.Response, error){
 		func() (*http.Response, error) {
-			req, _ := http.NewRequest("GET", userUrl, nil)
+			req, _ := http.NewRequest("GET", endpoints.User, nil)
 			SetCommonHeaders(req)
 			req.Header.Add("Authorization", "Bearer "+accessToken)
 			req.Header.Add("Referer", "https://kimi.moonshot.cn/")
 			return client.Do(req)
 		},
 		func() (*http.Response, error) {
-			req, _ := http.NewRequest("GET", userStatusUrl, nil)
+			req, _ := http.NewRequest("GET", endpoints.UserStatus, nil)
 			SetCommonHeaders(req)
 			req.Header.Add("Authorization", "Bearer "+accessToken)
 			req.Header.Add("Referer", "https://kimi.moonshot.cn/")
 			return client.Do(req)
 		},
 		func() (*http.Response, error) {
-			req, _ := http.NewRequest("POST", chatListUrl, bytes.NewBuffer([]byte(`{"offset":0, "size":50}`)))
+			req, _ := http.NewRequest("POST", endpoints.ChatList, bytes.NewBuffer([]byte(`{"offset":0, "size":50}`)))
 			SetCommonHeaders(req)
 			req.Header.Set("Content-Type", "application/json")
 			req.Header.Add("Authorization", "Bearer "+accessToken)
@@ -3,19 +3,27 @@ package kimi
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
-	"github.com/cphovo/ollm/util"
+	"github.com/cphovo/ollm/cache"
+	"github.com/cphovo/ollm/metrics"
+	"github.com/cphovo/ollm/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Kimi struct {
 	AccessToken  string
 	RefreshToken string
-	Store        *util.LazyExpiryKVStore
+	Store        cache.Backend
+	Endpoints    Endpoints
 }
 
 type AskStreamOptions struct {
@@ -44,26 +52,41 @@ type KimiCreateChatResponse struct {
 	Type      string `json:"type"`
 }
 
-const (
-	KimiCompletionStreamURL = "https://kimi.moonshot.cn/api/chat/%s/completion/stream"
-	KimiCreateChatURL       = "https://kimi.moonshot.cn/api/chat"
-)
+// Transport 为 nil 时使用 http.DefaultTransport，测试中替换为录制回放的 transport
+var Transport http.RoundTripper
+
+// TokenCache 缓存 access token，可以替换成文件或 redis 实现以在重启和多副本间共享。
+// 默认的缓存没有 janitor，被 main 替换后不会留下 goroutine，过期的 token 在读取时删除
+var TokenCache cache.Backend = cache.NewMemory(0, 0)
 
-var globalStore = util.NewLazyExpiryStore()
+// 不同的上游地址分开缓存
+func tokenCacheKey(endpoints Endpoints, refreshToken string) string {
+	sum := sha256.Sum256([]byte(endpoints.Base + "\n" + refreshToken))
+	return "kimi:token:" + hex.EncodeToString(sum[:])
+}
+
+// NewKimi returns a client for the given endpoints, the zero Endpoints is the official site.
+func NewKimi(ctx context.Context, refreshToken string, endpoints Endpoints) (*Kimi, error) {
+	endpoints = endpoints.withDefaults()
 
-func NewKimi(refreshToken string) (*Kimi, error) {
 	// 如果缓存中存在未失效的 TOKEN，直接使用
-	if token, ok := globalStore.Get(refreshToken); ok {
-		tokenResp := token.(KimiTokenResponse)
+	var tokenResp KimiTokenResponse
+	if ok, err := cache.GetJSON(TokenCache, tokenCacheKey(endpoints, refreshToken), &tokenResp); ok {
 		return &Kimi{
 			AccessToken:  tokenResp.AccessToken,
 			RefreshToken: tokenResp.RefreshToken,
-			Store:        globalStore,
+			Store:        TokenCache,
+			Endpoints:    endpoints,
 		}, nil
+	} else if err != nil {
+		slog.Warn("Cannot read kimi token from cache", "err", err)
 	}
 
 	// 否则获取新的 TOKEN
-	tokenResponse, err := GetToken(refreshToken)
+	ctx, span := tracing.Start(ctx, "kimi.token_refresh")
+	tokenResponse, err := GetToken(ctx, refreshToken, endpoints)
+	tracing.End(span, err)
+	metrics.TokenRefreshes.WithLabelValues("kimi", metrics.Result(err)).Inc()
 	if err != nil {
 		return nil, err
 	}
@@ -71,15 +94,24 @@ func NewKimi(refreshToken string) (*Kimi, error) {
 	kimi := &Kimi{
 		AccessToken:  tokenResponse.AccessToken,
 		RefreshToken: tokenResponse.RefreshToken,
-		Store:        globalStore,
+		Store:        TokenCache,
+		Endpoints:    endpoints,
 	}
 
-	kimi.Store.Set(refreshToken, tokenResponse, KimiTokenExpireTime)
+	if err := cache.SetJSON(kimi.Store, tokenCacheKey(endpoints, refreshToken), tokenResponse, KimiTokenExpireTime*time.Second); err != nil {
+		slog.Warn("Cannot save kimi token to cache", "err", err)
+	}
 
 	return kimi, nil
 }
 
-func (kimi *Kimi) CreateChat(name string) (string, error) {
+func (kimi *Kimi) CreateChat(ctx context.Context, name string) (convId string, err error) {
+	_, span := tracing.Start(ctx, "kimi.create_chat")
+	defer func() {
+		span.SetAttributes(attribute.String("kimi.conv_id", convId))
+		tracing.End(span, err)
+	}()
+
 	payload := KimiCreateChatPayload{
 		Name:      name,
 		IsExample: false,
@@ -89,7 +121,7 @@ func (kimi *Kimi) CreateChat(name string) (string, error) {
 		return "", fmt.Errorf("error marshalling payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", KimiCreateChatURL, bytes.NewReader(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", kimi.Endpoints.CreateChat, bytes.NewReader(payloadBytes))
 	if err != nil {
 		return "", err
 	}
@@ -98,7 +130,7 @@ func (kimi *Kimi) CreateChat(name string) (string, error) {
 	req.Header.Set("Referer", "https://kimi.moonshot.cn/")
 	SetCommonHeaders(req)
 
-	client := &http.Client{}
+	client := &http.Client{Transport: Transport}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("error making request: %w", err)
@@ -123,8 +155,12 @@ func (kimi *Kimi) CreateChat(name string) (string, error) {
 	return response.ID, nil
 }
 
-func (kimi *Kimi) AskStream(options AskStreamOptions) (<-chan Message, error) {
-	url := fmt.Sprintf(KimiCompletionStreamURL, options.ConvId)
+func (kimi *Kimi) AskStream(ctx context.Context, options AskStreamOptions) (<-chan Message, error) {
+	// span 在流结束时才结束
+	ctx, span := tracing.Start(ctx, "kimi.stream",
+		attribute.String("kimi.conv_id", options.ConvId),
+		attribute.Bool("kimi.use_search", options.UseSearch))
+	url := fmt.Sprintf(kimi.Endpoints.CompletionStream, options.ConvId)
 
 	payload := map[string]interface{}{
 		"messages": []map[string]string{
@@ -139,11 +175,13 @@ func (kimi *Kimi) AskStream(options AskStreamOptions) (<-chan Message, error) {
 
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
+		tracing.End(span, err)
 		return nil, fmt.Errorf("error marshalling payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadBytes))
 	if err != nil {
+		tracing.End(span, err)
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 
@@ -152,16 +190,32 @@ func (kimi *Kimi) AskStream(options AskStreamOptions) (<-chan Message, error) {
 	SetCommonHeaders(req)
 
 	client := &http.Client{
-		Timeout: 120 * time.Second, // 设置 120 超时时间
+		Transport: Transport,
+		Timeout:   120 * time.Second, // 设置 120 超时时间
 	}
 	resp, err := client.Do(req)
 	if err != nil {
+		tracing.End(span, err)
 		return nil, fmt.Errorf("error making request: %w", err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			metrics.Throttles.WithLabelValues("kimi").Inc()
+		}
+		err := fmt.Errorf("failed to ask kimi, status code: %d", resp.StatusCode)
+		tracing.End(span, err)
+		return nil, err
+	}
 
 	messages := make(chan Message)
 
 	go func() {
+		events := 0
+		defer func() {
+			span.SetAttributes(attribute.Int("kimi.events", events))
+			span.End()
+		}()
 		defer close(messages)
 		defer resp.Body.Close()
 
@@ -184,6 +238,7 @@ func (kimi *Kimi) AskStream(options AskStreamOptions) (<-chan Message, error) {
 					continue
 				}
 
+				events++
 				messages <- data
 			}
 		}
@@ -1,35 +1,70 @@
 package kimi
 
 import (
-	"fmt"
+	"context"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/cphovo/ollm/replay"
 )
 
 var refreshToken = "eyJhbGciOiJIUzUxMiI..."
 
 func TestKimiAskStream(t *testing.T) {
-	kimi, err := NewKimi(refreshToken)
+	session, err := replay.Start("testdata/chat.json")
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
+	}
+	Transport = session.Transport(nil)
+	t.Cleanup(func() {
+		Transport = nil
+		if err := session.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// 录制时需要真实的 refreshToken
+	token := refreshToken
+	if v := os.Getenv("KIMI_REFRESH_TOKEN"); v != "" {
+		token = v
 	}
 
-	convId, err := kimi.CreateChat("未命名会话")
+	ctx := context.Background()
+	kimi, err := NewKimi(ctx, token, Endpoints{})
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 
-	messages, err := kimi.AskStream(AskStreamOptions{
+	convId, err := kimi.CreateChat(ctx, "未命名会话")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if convId != "cp1kimichat0000000000" {
+		t.Errorf("convId = %q", convId)
+	}
+
+	messages, err := kimi.AskStream(ctx, AskStreamOptions{
 		Text:      "hello",
 		ConvId:    convId,
 		UseSearch: true,
 	})
-
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 
+	var events []string
+	var builder strings.Builder
 	for message := range messages {
-		data := fmt.Sprintf("event: %s\ndata: %s\n", message.Event, message.Text)
-		fmt.Println(data)
+		events = append(events, message.Event)
+		if message.Event == "cmpl" {
+			builder.WriteString(message.Text)
+		}
+	}
+	if strings.Join(events, ",") != "resp,cmpl,cmpl,all_done" {
+		t.Errorf("events = %v", events)
+	}
+	if builder.String() != "你好！有什么可以帮你的吗？" {
+		t.Errorf("text = %q", builder.String())
 	}
 }
@@ -1,26 +1,25 @@
 package kimi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 )
 
-const (
-	KimiRefreshTokenURL = "https://kimi.moonshot.cn/api/auth/token/refresh"
-	// Token 过期时间设置为 300 秒
-	KimiTokenExpireTime = 5 * 60
-)
+// Token 过期时间设置为 300 秒
+const KimiTokenExpireTime = 5 * 60
 
 type KimiTokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
 
-func GetToken(kimiAuthToken string) (tokenResponse KimiTokenResponse, err error) {
+func GetToken(ctx context.Context, kimiAuthToken string, endpoints Endpoints) (tokenResponse KimiTokenResponse, err error) {
+	endpoints = endpoints.withDefaults()
 
-	req, err := http.NewRequest("GET", KimiRefreshTokenURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoints.RefreshToken, nil)
 	if err != nil {
 		return
 	}
@@ -29,7 +28,7 @@ func GetToken(kimiAuthToken string) (tokenResponse KimiTokenResponse, err error)
 	req.Header.Add("Authorization", "Bearer "+kimiAuthToken)
 	req.Header.Add("Referer", "https://kimi.moonshot.cn/")
 
-	client := &http.Client{}
+	client := &http.Client{Transport: Transport}
 	resp, err := client.Do(req)
 	if err != nil {
 		return
@@ -1,13 +1,18 @@
 package kimi
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"testing"
 )
 
 func TestGetToken(t *testing.T) {
-	var refreshToken = "eyJhbGciOiJIUzUxMiI..."
-	resp, err := GetToken(refreshToken)
+	refreshToken := os.Getenv("KIMI_REFRESH_TOKEN")
+	if refreshToken == "" {
+		t.Skip("KIMI_REFRESH_TOKEN not set")
+	}
+	resp, err := GetToken(context.Background(), refreshToken, Endpoints{})
 	if err != nil {
 		fmt.Println(err)
 	}
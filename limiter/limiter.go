@@ -0,0 +1,174 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrQueueFull    = errors.New("too many requests waiting for this upstream account")
+	ErrQueueTimeout = errors.New("timed out waiting for this upstream account")
+)
+
+type Config struct {
+	// Requests per second per account, zero means unlimited
+	RPS   float64
+	Burst int
+	// Requests in flight per account, zero means unlimited
+	MaxConcurrent int
+	// Requests waiting per account before ErrQueueFull, zero means unlimited
+	MaxQueue int
+	// How long a request may wait, zero means until the request is cancelled
+	QueueTimeout time.Duration
+}
+
+// Limiter applies a token bucket and a concurrency limit to every upstream
+// account (cookie set, refresh token, api key...) separately.
+type Limiter struct {
+	config Config
+	// how often idle accounts are dropped
+	sweepInterval time.Duration
+
+	mutex     sync.Mutex
+	accounts  map[string]*account
+	lastSweep time.Time
+}
+
+type account struct {
+	rate    *rate.Limiter
+	slots   chan struct{}
+	waiting int
+}
+
+type AccountStatus struct {
+	Account  string `json:"account"`
+	InFlight int    `json:"inFlight"`
+	Waiting  int    `json:"waiting"`
+}
+
+func New(config Config) *Limiter {
+	if config.RPS > 0 && config.Burst <= 0 {
+		config.Burst = 1
+	}
+	return &Limiter{
+		config:        config,
+		sweepInterval: time.Minute,
+		accounts:      map[string]*account{},
+		lastSweep:     time.Now(),
+	}
+}
+
+// sweep drops the accounts with nothing in flight or waiting and a full token
+// bucket, they are recreated in the same state on the next request.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, a := range l.accounts {
+		if a.waiting > 0 || len(a.slots) > 0 {
+			continue
+		}
+		if a.rate != nil && a.rate.TokensAt(now) < float64(l.config.Burst) {
+			continue
+		}
+		delete(l.accounts, key)
+	}
+}
+
+func (l *Limiter) account(key string) *account {
+	a, ok := l.accounts[key]
+	if !ok {
+		a = &account{}
+		if l.config.RPS > 0 {
+			a.rate = rate.NewLimiter(rate.Limit(l.config.RPS), l.config.Burst)
+		}
+		if l.config.MaxConcurrent > 0 {
+			a.slots = make(chan struct{}, l.config.MaxConcurrent)
+		}
+		l.accounts[key] = a
+	}
+	return a
+}
+
+// Acquire waits until the account may send another request. The returned
+// release func must be called when the request is done.
+func (l *Limiter) Acquire(ctx context.Context, key string) (release func(), err error) {
+	l.mutex.Lock()
+	l.sweep(time.Now())
+	a := l.account(key)
+	if l.config.MaxQueue > 0 && a.waiting >= l.config.MaxQueue {
+		l.mutex.Unlock()
+		return nil, ErrQueueFull
+	}
+	a.waiting++
+	l.mutex.Unlock()
+
+	defer func() {
+		l.mutex.Lock()
+		a.waiting--
+		l.mutex.Unlock()
+	}()
+
+	if l.config.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.config.QueueTimeout)
+		defer cancel()
+	}
+
+	if a.slots != nil {
+		select {
+		case a.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, waitError(ctx)
+		}
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if a.slots != nil {
+				<-a.slots
+			}
+		})
+	}
+
+	if a.rate != nil {
+		if err := a.rate.Wait(ctx); err != nil {
+			release()
+			// rate.Wait 在等待时间超过 deadline 时直接返回错误，同样视为超时
+			if ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrQueueTimeout
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+func waitError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrQueueTimeout
+	}
+	return ctx.Err()
+}
+
+func (l *Limiter) Status() []AccountStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	status := []AccountStatus{}
+	for key, a := range l.accounts {
+		status = append(status, AccountStatus{
+			Account:  key,
+			InFlight: len(a.slots),
+			Waiting:  a.waiting,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Account < status[j].Account })
+	return status
+}
@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConcurrency(t *testing.T) {
+	l := New(Config{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 其他账号不受影响
+	releaseB, err := l.Acquire(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseB()
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Acquire(ctx, "a")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := l.Acquire(ctx, "a"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+
+	release()
+	release() // 重复调用不能多释放
+	release, err = l.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestRate(t *testing.T) {
+	l := New(Config{RPS: 1, Burst: 1, QueueTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := l.Acquire(ctx, "a"); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(Config{RPS: 1000, Burst: 1, MaxConcurrent: 1})
+	l.sweepInterval = 0
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, "busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseIdle, err := l.Acquire(ctx, "idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseIdle()
+
+	// 等 idle 的令牌桶补满
+	time.Sleep(10 * time.Millisecond)
+	releaseOther, err := l.Acquire(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseOther()
+	status := l.Status()
+	if len(status) != 2 || status[0].Account != "busy" || status[1].Account != "other" {
+		t.Fatalf("status = %+v, want busy and other", status)
+	}
+	release()
+}
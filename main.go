@@ -1,13 +1,35 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log/slog"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/cphovo/ollm/audit"
+	"github.com/cphovo/ollm/bypass"
+	"github.com/cphovo/ollm/cache"
+	"github.com/cphovo/ollm/conversation"
+	"github.com/cphovo/ollm/gemini"
 	"github.com/cphovo/ollm/handler"
+	"github.com/cphovo/ollm/kimi"
+	"github.com/cphovo/ollm/limiter"
+	"github.com/cphovo/ollm/metrics"
+	"github.com/cphovo/ollm/mirror"
+	"github.com/cphovo/ollm/mockupstream"
+	"github.com/cphovo/ollm/sydney"
+	"github.com/cphovo/ollm/tracing"
 	"github.com/cphovo/ollm/util"
 	"github.com/gin-gonic/gin"
 )
@@ -22,10 +44,30 @@ var (
 	// proxy          string
 	allowedOrigins string
 	// defaultCookies map[string]string
-	authToken string
+	// token -> name of the API key
+	authTokens map[string]string
+	// protects the /admin routes, separate from the API keys
+	adminToken string
+	// flushes pending spans
+	shutdownTracing func(context.Context) error
+	// how long in-flight requests may run after SIGTERM
+	shutdownTimeout time.Duration
+	// run in order after the server stopped, to flush caches, cookies and logs
+	closers []closer
 )
 
-func init() {
+type closer struct {
+	name  string
+	close func() error
+}
+
+// onShutdown registers a function to run after the server stopped
+func onShutdown(name string, close func() error) {
+	closers = append(closers, closer{name, close})
+}
+
+// setup reads the envs and configures the handlers
+func setup() {
 	// load envs
 	err := util.LoadEnv(".env")
 	if err != nil {
@@ -49,6 +91,8 @@ func init() {
 		allowedOrigins = "*"
 	}
 
+	shutdownTimeout = time.Duration(envInt("SHUTDOWN_TIMEOUT", 30)) * time.Second
+
 	defaultCookies := util.ParseCookies(os.Getenv("DEFAULT_COOKIES"))
 
 	if len(defaultCookies) == 0 {
@@ -56,6 +100,9 @@ func init() {
 		defaultCookies, _ = util.ReadCookiesFile()
 		if len(defaultCookies) == 0 {
 			slog.Warn("cookies.json not found, using empty cookies")
+		} else {
+			// 解决验证码后 cookie 会被更新，退出前写回文件
+			onShutdown("cookies", func() error { return util.UpdateCookiesFile(defaultCookies) })
 		}
 	} else {
 		slog.Info("DEFAULT_COOKIES set, cookies.json will be ignored")
@@ -67,29 +114,223 @@ func init() {
 		panic("在这里提供你默认的 refreshToken")
 	}
 
-	geminiAPIKey := os.Getenv("GEMINI_API_KEY")
-	if geminiAPIKey == "" {
+	// GEMINI_API_KEYS 可以配置多个 key，用逗号分隔
+	geminiAPIKeys := strings.Split(os.Getenv("GEMINI_API_KEYS"), ",")
+	geminiAPIKeys = append(geminiAPIKeys, os.Getenv("GEMINI_API_KEY"))
+	geminiKeyPool := gemini.NewKeyPool(geminiAPIKeys,
+		envInt("GEMINI_KEY_RPM", 15),
+		envInt("GEMINI_KEY_RPD", 1500),
+		time.Duration(envInt("GEMINI_KEY_COOLDOWN", 60))*time.Second)
+	if geminiKeyPool.Len() == 0 {
 		panic("在这里提供你默认的 Gemini API Key")
 	}
 
+	geminiSafetySettings, err := gemini.ReadSafetySettingsFile()
+	if err != nil {
+		panic(err)
+	}
+
+	// CACHE_BACKEND 可选 memory、file 和 redis，file 和 redis 可以让 token 在重启后保留
+	cacheConfig := cache.Config{
+		Backend:         os.Getenv("CACHE_BACKEND"),
+		MaxEntries:      envInt("CACHE_MAX_ENTRIES", 10000),
+		JanitorInterval: time.Duration(envInt("CACHE_JANITOR_INTERVAL", 60)) * time.Second,
+		Path:            util.Ternary(os.Getenv("CACHE_FILE") == "", util.WithPath("cache.json"), os.Getenv("CACHE_FILE")),
+		RedisURL:        os.Getenv("CACHE_REDIS_URL"),
+		Prefix:          "ollm:",
+	}
+	cacheBackend, err := cache.New(cacheConfig)
+	if err != nil {
+		panic(err)
+	}
+	onShutdown("cache", cacheBackend.Close)
+
+	// RESPONSE_CACHE_TTL 大于 0 时开启响应缓存，使用单独的容量限制和 key 前缀
+	if ttl := envInt("RESPONSE_CACHE_TTL", 0); ttl > 0 {
+		// redis 不限制条目数量，需要在 Redis 上配置 maxmemory
+		if cacheConfig.Backend == "redis" && os.Getenv("RESPONSE_CACHE_MAX_ENTRIES") != "" {
+			panic("RESPONSE_CACHE_MAX_ENTRIES is not supported with CACHE_BACKEND=redis, set maxmemory on the Redis server instead")
+		}
+		responseCacheConfig := cacheConfig
+		responseCacheConfig.MaxEntries = envInt("RESPONSE_CACHE_MAX_ENTRIES", 1000)
+		responseCacheConfig.Path = util.WithPath("response_cache.json")
+		responseCacheConfig.Prefix = "ollm:response:"
+		responseCache, err := cache.New(responseCacheConfig)
+		if err != nil {
+			panic(err)
+		}
+		onShutdown("response cache", responseCache.Close)
+		handler.ResponseCache = responseCache
+		handler.ResponseCacheTTL = time.Duration(ttl) * time.Second
+	}
+
+	// 配置 CONVERSATION_DB 后开启服务端会话存储
+	if path := os.Getenv("CONVERSATION_DB"); path != "" {
+		store, err := conversation.Open(path)
+		if err != nil {
+			panic(err)
+		}
+		handler.ConversationStore = store
+		onShutdown("conversations", store.Close)
+	}
+
+	// 每个上游账号（cookie、refreshToken、API Key）单独限流，默认同一个账号最多 3 个并发
+	handler.UpstreamLimiters = map[string]*limiter.Limiter{
+		"bing":   envLimiter("BING", 3),
+		"kimi":   envLimiter("KIMI", 3),
+		"gemini": envLimiter("GEMINI", 0),
+	}
+
+	handler.Cache = cacheBackend
+	kimi.TokenCache = cacheBackend
 	handler.Proxy = proxy
+
+	// 上游地址可以指向镜像、反向代理或 mock upstream
+	endpoints, err := readEndpoints()
+	if err != nil {
+		panic(err)
+	}
+	handler.BingEndpoints = endpoints.Bing
+	handler.KimiEndpoints = endpoints.Kimi
+	handler.GeminiEndpoint = endpoints.Gemini
+	handler.BingLocales, err = readBingLocales()
+	if err != nil {
+		panic(err)
+	}
+	// bing_plugins.json 中的插件和 persona，带 model 的会注册为单独的模型
+	bingRegistry, err := sydney.ReadRegistryFile()
+	if err != nil {
+		panic(err)
+	}
+	sydney.Register(bingRegistry)
+	// bing_option_sets.json 可以通过 /admin/bing/option-sets/reload 重新加载
+	optionSetProfiles, err := sydney.ReadOptionSetProfilesFile()
+	if err != nil {
+		panic(err)
+	}
+	if err := sydney.SetOptionSetProfiles(optionSetProfiles); err != nil {
+		panic(err)
+	}
+	if err := handler.RegisterBingModels(bingRegistry); err != nil {
+		panic(err)
+	}
 	handler.DefaultCookies = defaultCookies
+	handler.CaptchaSolver, err = newCaptchaSolver()
+	if err != nil {
+		panic(err)
+	}
 	handler.DefaultRefreshToken = refreshToken
-	handler.DefaultAPIKey = geminiAPIKey
+	handler.GeminiKeyPool = geminiKeyPool
+	handler.GeminiSafetySettings = geminiSafetySettings
+	// 为没有建议回复的 provider 生成建议回复的 Gemini 模型，none 关闭
+	switch model := os.Getenv("SUGGESTIONS_MODEL"); model {
+	case "":
+		handler.SuggestionsModel = "gemini-1.5-flash"
+	case "none":
+		handler.SuggestionsModel = ""
+	default:
+		handler.SuggestionsModel = model
+	}
+	// FILES_DIR 配置后保存代码解释器生成的文件，上游的链接会随会话失效
+	if dir := os.Getenv("FILES_DIR"); dir != "" {
+		client, _, err := util.MakeHTTPClient(proxy, 60*time.Second)
+		if err != nil {
+			panic(err)
+		}
+		handler.FileMirror, err = mirror.New(mirror.Options{
+			Dir:     dir,
+			BaseURL: strings.TrimSuffix(os.Getenv("FILES_BASE_URL"), "/") + "/files/",
+			MaxSize: int64(envInt("FILES_MAX_SIZE", 20)) << 20,
+			Client:  client,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	authTokens, err = readAuthTokens()
+	if err != nil {
+		panic(err)
+	}
+	adminToken = os.Getenv("ADMIN_TOKEN")
+
+	// AUDIT_LOG 配置后每个请求写一行 JSON 审计日志
+	if path := os.Getenv("AUDIT_LOG"); path != "" {
+		out, err := audit.NewRotatingFile(path,
+			int64(envInt("AUDIT_LOG_MAX_SIZE", 100))<<20,
+			envInt("AUDIT_LOG_MAX_BACKUPS", 5))
+		if err != nil {
+			panic(err)
+		}
+		onShutdown("audit log", out.Close)
+		patterns, err := audit.ReadRedactPatternsFile()
+		if err != nil {
+			panic(err)
+		}
+		redactor, err := audit.NewRedactor(patterns)
+		if err != nil {
+			panic(err)
+		}
+		handler.AuditLogger = audit.NewLogger(out, redactor, os.Getenv("AUDIT_LOG_BODIES") == "true")
+	}
 
-	authToken = os.Getenv("AUTH_TOKEN")
+	// TRACE_EXPORTER 可选 otlp 和 stdout，otlp 的地址等通过标准的 OTEL_EXPORTER_OTLP_* 环境变量配置
+	shutdownTracing, err = tracing.Setup(context.Background(), os.Getenv("TRACE_EXPORTER"), "ollm")
+	if err != nil {
+		panic(err)
+	}
+	onShutdown("tracing", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
 }
 
 func main() {
-	r := gin.Default()
+	if len(os.Args) > 1 && os.Args[1] == "mock-upstream" {
+		runMockUpstream(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "captcha-bypass" {
+		runCaptchaBypass(os.Args[2:])
+		return
+	}
 
+	setup()
+
+	r := gin.New()
+
+	r.Use(gin.Logger())
+	// handler 中的 panic 返回 500，不会让整个进程退出
+	r.Use(handler.Recovery())
+
+	r.Use(tracing.Middleware())
 	r.Use(CORSMiddleware())
-	r.Use(AuthMiddleware(authToken))
+	// 审计日志在鉴权之前，未授权的请求也会被记录
+	r.Use(handler.AuditMiddleware())
+	// 文件名是不可猜测的哈希，不需要鉴权，客户端可以直接用 <img> 显示
+	if handler.FileMirror != nil {
+		r.GET("/files/:name", handler.FileMirror.Handler())
+	}
+
+	// ADMIN 使用单独的 ADMIN_TOKEN，API key 不能访问
+	admin := r.Group("/admin", AdminAuthMiddleware(adminToken, len(authTokens) > 0))
+	admin.GET("/gemini/keys", handler.GeminiKeyPoolHandler)
+	admin.GET("/cache", handler.CacheStatsHandler)
+	admin.GET("/limiters", handler.UpstreamLimitersHandler)
+	admin.GET("/captchas", handler.PendingCaptchasHandler)
+	admin.POST("/captchas/:id", handler.ResolveCaptchaHandler)
+	admin.GET("/bing/option-sets", handler.OptionSetProfilesHandler)
+	admin.POST("/bing/option-sets/reload", handler.ReloadOptionSetProfilesHandler)
+
+	r.Use(AuthMiddleware(authTokens))
 
 	r.GET("/", RootHandler)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	// COMMON
-	r.POST("/v1/chat/completions", handler.ModelBasedDispatcher())
+	r.POST("/v1/chat/completions", handler.ResponseCacheMiddleware(), handler.ModelBasedDispatcher())
+	r.POST("/v1/embeddings", handler.ModelBasedEmbeddingDispatcher())
 
 	// BING AI
 	r.POST("/image/upload", handler.BingImageUploadHandler)
@@ -106,7 +347,232 @@ func main() {
 	r.POST("/gemini/chat/stream", handler.GeminiStreamChatHandler)
 	r.POST("/v1/gemini/chat/completions", handler.GeminiCompleteChatHandler)
 
-	r.Run(fmt.Sprintf(":%s", port))
+	// CONVERSATIONS
+	r.POST("/v1/conversations", handler.CreateConversationHandler)
+	r.GET("/v1/conversations", handler.ListConversationsHandler)
+	r.GET("/v1/conversations/:id", handler.GetConversationHandler)
+	r.PATCH("/v1/conversations/:id", handler.UpdateConversationHandler)
+	r.DELETE("/v1/conversations/:id", handler.DeleteConversationHandler)
+
+	// 请求的 context 派生自 baseCtx，超时后用 ErrShutdown 取消仍在进行的流
+	baseCtx, cancelRequests := context.WithCancelCause(context.Background())
+	server := &http.Server{
+		Addr:        fmt.Sprintf(":%s", port),
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "addr", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Server stopped", "err", err)
+	case <-ctx.Done():
+		// 再次收到信号时直接退出
+		stop()
+		shutdown(server, cancelRequests)
+	}
+
+	for _, c := range closers {
+		if err := c.close(); err != nil {
+			slog.Warn("Failed to close", "name", c.name, "err", err)
+		}
+	}
+}
+
+// shutdown stops accepting requests and waits for the in-flight ones until
+// shutdownTimeout, then cancels them so that streams end with an error chunk.
+func shutdown(server *http.Server, cancelRequests context.CancelCauseFunc) {
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err == nil {
+		return
+	}
+
+	slog.Warn("Shutdown timeout expired, cancelling in-flight requests")
+	cancelRequests(handler.ErrShutdown)
+	// 给被取消的请求一点时间写出最后的错误信息
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+	}
+}
+
+// runMockUpstream serves fake Bing, Kimi and Gemini APIs for development
+func runMockUpstream(args []string) {
+	flags := flag.NewFlagSet("mock-upstream", flag.ExitOnError)
+	port := flags.String("port", "8081", "port to listen on")
+	scenario := flags.String("scenario", mockupstream.ScenarioOK,
+		"default scenario: ok, throttle, captcha, apology, revoke or unauthorized")
+	reply := flags.String("reply", "", "reply text, defaults to echoing the prompt")
+	flags.Parse(args)
+
+	server := mockupstream.New(mockupstream.Options{Scenario: *scenario, Reply: *reply})
+	slog.Info("Mock upstream listening", "port", *port, "scenario", *scenario)
+	if err := http.ListenAndServe(":"+*port, server.Handler()); err != nil {
+		slog.Error("Mock upstream stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+// runCaptchaBypass serves the CAPTCHA bypass protocol used by BYPASS_SERVER
+func runCaptchaBypass(args []string) {
+	flags := flag.NewFlagSet("captcha-bypass", flag.ExitOnError)
+	port := flags.String("port", "8082", "port to listen on")
+	bing := flags.String("bing", "https://www.bing.com", "Bing endpoint")
+	headless := flags.Bool("headless", true, "run Chrome without a window")
+	timeout := flags.Int("timeout", 60, "seconds to wait for a challenge")
+	screenshots := flags.String("screenshots", "", "directory of the screenshots, defaults to a temporary directory")
+	maxConcurrent := flags.Int("max-concurrent", 2, "browsers running at the same time")
+	flags.Parse(args)
+
+	server, err := bypass.New(bypass.Options{
+		Bing:          *bing,
+		Headless:      *headless,
+		Timeout:       time.Duration(*timeout) * time.Second,
+		ScreenshotDir: *screenshots,
+		MaxConcurrent: *maxConcurrent,
+	})
+	if err != nil {
+		slog.Error("Cannot create captcha bypass server", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("Captcha bypass server listening", "port", *port, "headless", *headless)
+	if err := http.ListenAndServe(":"+*port, server.Handler()); err != nil {
+		slog.Error("Captcha bypass server stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+type endpointsConfig struct {
+	Bing   sydney.Endpoints `json:"bing"`
+	Kimi   kimi.Endpoints   `json:"kimi"`
+	Gemini string           `json:"gemini"`
+}
+
+// readEndpoints reads endpoints.json, the BING_*, KIMI_BASE_URL and GEMINI_BASE_URL envs take precedence.
+func readEndpoints() (endpointsConfig, error) {
+	var config endpointsConfig
+	if v, err := os.ReadFile(util.WithPath("endpoints.json")); err == nil {
+		if err := json.Unmarshal(v, &config); err != nil {
+			return config, fmt.Errorf("failed to json.Unmarshal content of endpoints file: %w", err)
+		}
+	}
+	envs := map[string]*string{
+		"BING_BASE_URL":                &config.Bing.Bing,
+		"BING_SYDNEY_URL":              &config.Bing.Sydney,
+		"BING_WSS_URL":                 &config.Bing.ChatHub,
+		"BING_CREATE_CONVERSATION_URL": &config.Bing.CreateConversation,
+		"BING_THUMBNAIL_URL":           &config.Bing.Thumbnail,
+		"KIMI_BASE_URL":                &config.Kimi.Base,
+		"GEMINI_BASE_URL":              &config.Gemini,
+	}
+	for key, field := range envs {
+		if v := os.Getenv(key); v != "" {
+			*field = v
+		}
+	}
+	return config, nil
+}
+
+// newCaptchaSolver reads CAPTCHA_SOLVER (browser, bypass or manual), defaults to
+// bypass when BYPASS_SERVER is set and browser otherwise.
+func newCaptchaSolver() (sydney.CaptchaSolver, error) {
+	solver := os.Getenv("CAPTCHA_SOLVER")
+	bypassServer := os.Getenv("BYPASS_SERVER")
+	if solver == "" {
+		solver = util.Ternary(bypassServer == "", "browser", "bypass")
+	}
+	switch solver {
+	case "browser":
+		return sydney.BrowserSolver{
+			Headless: os.Getenv("CAPTCHA_HEADLESS") == "true",
+			Timeout:  time.Duration(envInt("CAPTCHA_TIMEOUT", 60)) * time.Second,
+		}, nil
+	case "bypass":
+		if bypassServer == "" {
+			return nil, fmt.Errorf("BYPASS_SERVER is required by the bypass captcha solver")
+		}
+		return sydney.BypassServerSolver{URL: bypassServer}, nil
+	case "manual":
+		return sydney.NewManualSolver(time.Duration(envInt("CAPTCHA_TIMEOUT", 600))*time.Second,
+			os.Getenv("CAPTCHA_NOTIFY_URL")), nil
+	default:
+		return nil, fmt.Errorf("unknown CAPTCHA_SOLVER: %s", solver)
+	}
+}
+
+// readBingLocales reads bing_locations.json, BING_LOCATION, BING_LOCALE and BING_MARKET override its default.
+func readBingLocales() (handler.BingLocaleConfig, error) {
+	var config handler.BingLocaleConfig
+	if v, err := os.ReadFile(util.WithPath("bing_locations.json")); err == nil {
+		if err := json.Unmarshal(v, &config); err != nil {
+			return config, fmt.Errorf("failed to json.Unmarshal content of bing locations file: %w", err)
+		}
+	}
+	envs := map[string]*string{
+		"BING_LOCATION": &config.Default.Location,
+		"BING_LOCALE":   &config.Default.Locale,
+		"BING_MARKET":   &config.Default.Market,
+	}
+	for key, field := range envs {
+		if v := os.Getenv(key); v != "" {
+			*field = v
+		}
+	}
+	// 预设名称不区分大小写
+	presets := map[string]sydney.Location{}
+	for name, location := range config.Presets {
+		presets[strings.ToLower(name)] = location
+	}
+	config.Presets = presets
+	// 启动时检查位置名称，避免每个请求都失败
+	locales := map[string]handler.BingLocale{"default": config.Default}
+	for name, locale := range config.Keys {
+		locales["key "+name] = locale
+	}
+	for name, locale := range locales {
+		if locale.Location == "" {
+			continue
+		}
+		location := strings.ToLower(locale.Location)
+		_, custom := presets[location]
+		_, builtin := sydney.LocationPresets[location]
+		if !custom && !builtin {
+			return config, fmt.Errorf("unknown location of %s in bing locations: %s", name, locale.Location)
+		}
+	}
+	return config, nil
+}
+
+// envInt reads an integer env, falling back to def when it is unset or invalid
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envLimiter reads <provider>_RPS, _BURST, _MAX_CONCURRENT, _MAX_QUEUE and _QUEUE_TIMEOUT
+func envLimiter(provider string, defaultConcurrent int) *limiter.Limiter {
+	rps, _ := strconv.ParseFloat(os.Getenv(provider+"_RPS"), 64)
+	return limiter.New(limiter.Config{
+		RPS:           rps,
+		Burst:         envInt(provider+"_BURST", 1),
+		MaxConcurrent: envInt(provider+"_MAX_CONCURRENT", defaultConcurrent),
+		MaxQueue:      envInt(provider+"_MAX_QUEUE", 20),
+		QueueTimeout:  time.Duration(envInt(provider+"_QUEUE_TIMEOUT", 60)) * time.Second,
+	})
 }
 
 func CORSMiddleware() gin.HandlerFunc {
@@ -125,17 +591,71 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-func AuthMiddleware(authToken string) gin.HandlerFunc {
+// readAuthTokens collects the API keys from AUTH_TOKEN (named "default"),
+// AUTH_TOKENS ("name:token,name:token") and api_keys.json ({"name": "token"}).
+func readAuthTokens() (map[string]string, error) {
+	tokens := map[string]string{}
+	if token := os.Getenv("AUTH_TOKEN"); token != "" {
+		tokens[token] = "default"
+	}
+	for _, pair := range strings.Split(os.Getenv("AUTH_TOKENS"), ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && token != "" {
+			tokens[token] = name
+		}
+	}
+	v, err := os.ReadFile(util.WithPath("api_keys.json"))
+	if err != nil {
+		return tokens, nil
+	}
+	var keys map[string]string
+	if err := json.Unmarshal(v, &keys); err != nil {
+		return nil, fmt.Errorf("failed to json.Unmarshal content of api keys file: %w", err)
+	}
+	for name, token := range keys {
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+func AuthMiddleware(authTokens map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(authTokens) == 0 {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		name, known := authTokens[token]
+		if !ok || !known {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set(handler.APIKeyNameKey, name)
+
+		c.Next()
+	}
+}
+
+// AdminAuthMiddleware checks the ADMIN_TOKEN. Without it the admin routes are
+// only open when no API key is configured either, e.g. in local development.
+func AdminAuthMiddleware(adminToken string, hasAuthTokens bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if authToken == "" {
+		if adminToken == "" {
+			if hasAuthTokens {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin routes are disabled, set ADMIN_TOKEN"})
+				return
+			}
 			c.Next()
 			return
 		}
 
-		if c.GetHeader("Authorization") != "Bearer "+authToken {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
+		c.Set(handler.APIKeyNameKey, "admin")
 
 		c.Next()
 	}
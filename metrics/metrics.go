@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 生成类请求耗时较长，桶从 100ms 到 2 分钟
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120}
+
+var (
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollm_requests_total",
+		Help: "Chat completion requests by provider, model and status (ok or error).",
+	}, []string{"provider", "model", "status"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ollm_request_duration_seconds",
+		Help:    "Total time of chat completion requests.",
+		Buckets: latencyBuckets,
+	}, []string{"provider", "model"})
+
+	TimeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ollm_time_to_first_token_seconds",
+		Help:    "Time until the first text of the answer arrived from upstream.",
+		Buckets: latencyBuckets,
+	}, []string{"provider", "model"})
+
+	Tokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollm_completion_tokens_total",
+		Help: "Estimated tokens of the answers sent to clients.",
+	}, []string{"provider", "model"})
+
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollm_errors_total",
+		Help: "Failed chat completion requests by error type.",
+	}, []string{"provider", "model", "type"})
+
+	CaptchaEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollm_captcha_events_total",
+		Help: "Bing CAPTCHA challenges: detected, solved, failed or unresolved (CAPTCHA again after solving).",
+	}, []string{"event"})
+
+	Throttles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollm_upstream_throttles_total",
+		Help: "Requests throttled or rate limited by the upstream.",
+	}, []string{"provider"})
+
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollm_token_refreshes_total",
+		Help: "Access token refreshes by result (ok or error).",
+	}, []string{"provider", "result"})
+
+	AccountHealth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ollm_upstream_account_healthy",
+		Help: "1 when the last request of a configured upstream account succeeded, 0 when it failed because of the account (auth, throttle, CAPTCHA).",
+	}, []string{"provider", "account"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Result turns an error into the result label.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
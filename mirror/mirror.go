@@ -0,0 +1,261 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Options of a Mirror
+type Options struct {
+	// Directory of the local copies
+	Dir string
+	// Prefix of the local URLs, e.g. "https://example.com/files/"
+	BaseURL string
+	// Larger files are not mirrored, 0 means no limit
+	MaxSize int64
+	Client  *http.Client
+	// Allows loopback and private addresses, only for tests
+	AllowPrivate bool
+}
+
+// Mirror keeps local copies of upstream files, e.g. the charts of the Bing
+// code interpreter whose links expire with the conversation.
+type Mirror struct {
+	options Options
+}
+
+// 文件名是 URL 的哈希加上扩展名
+var fileName = regexp.MustCompile(`^[0-9a-f]{32}(\.[0-9a-z]+)?$`)
+
+var errPrivateAddress = errors.New("refusing to download from a private address")
+
+func New(options Options) (*Mirror, error) {
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+	client := http.Client{}
+	if options.Client != nil {
+		client = *options.Client
+	}
+	if !options.AllowPrivate {
+		client.Transport = guardTransport(client.Transport)
+	}
+	// 重定向只检查 scheme，内网地址在连接时检查
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkScheme(req.URL)
+	}
+	options.Client = &client
+	return &Mirror{options: options}, nil
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+	return nil
+}
+
+// privateIP reports loopback, private and link-local addresses, e.g. the
+// cloud metadata endpoint.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// checkDialAddress runs after the name is resolved, so the address checked is
+// the one connected to and a second DNS answer cannot point elsewhere.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// checkHost resolves host and refuses private addresses. Only used in front
+// of a proxy, which resolves the host again by itself.
+func checkHost(ctx context.Context, host string) error {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if privateIP(ip) {
+			return fmt.Errorf("%w: %s", errPrivateAddress, host)
+		}
+	}
+	return nil
+}
+
+// guardTransport refuses connections to private addresses. The proxy is set
+// by the operator and may be private, the hosts requested through it are
+// checked before they are handed to the proxy.
+func guardTransport(base http.RoundTripper) http.RoundTripper {
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+
+	direct := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	guarded := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkDialAddress}
+	var proxies sync.Map
+	if proxy := transport.Proxy; proxy != nil {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			proxyURL, err := proxy(req)
+			if err != nil || proxyURL == nil {
+				return proxyURL, err
+			}
+			if err := checkHost(req.Context(), req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			proxies.Store(proxyAddress(proxyURL), true)
+			return proxyURL, nil
+		}
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := proxies.Load(address); ok {
+			return direct.DialContext(ctx, network, address)
+		}
+		return guarded.DialContext(ctx, network, address)
+	}
+	return transport
+}
+
+// proxyAddress is the host:port the transport dials for the proxy
+func proxyAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// existing returns the name of a file already downloaded from the URL hash
+func (m *Mirror) existing(hash string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(m.options.Dir, hash+"*"))
+	for _, match := range matches {
+		if name := filepath.Base(match); fileName.MatchString(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Save downloads the file and returns its local URL. Files are named after
+// the hash of the upstream URL, so each file is only downloaded once.
+func (m *Mirror) Save(ctx context.Context, rawURL string, header http.Header) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	hash := hex.EncodeToString(sum[:16])
+	if name, ok := m.existing(hash); ok {
+		return m.options.BaseURL + name, nil
+	}
+	if err := checkScheme(u); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := m.options.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", u.Path, resp.Status)
+	}
+	if m.options.MaxSize > 0 && resp.ContentLength > m.options.MaxSize {
+		return "", fmt.Errorf("download %s: file is larger than %d bytes", u.Path, m.options.MaxSize)
+	}
+	// 优先用 URL 的扩展名，没有时按 Content-Type 补上
+	name := hash
+	if ext := path.Ext(u.Path); ext != "" && fileName.MatchString(hash+ext) {
+		name += ext
+	} else if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 && fileName.MatchString(hash+exts[0]) {
+			name += exts[0]
+		}
+	}
+
+	tmp, err := os.CreateTemp(m.options.Dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	body := io.Reader(resp.Body)
+	if m.options.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, m.options.MaxSize+1)
+	}
+	n, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if m.options.MaxSize > 0 && n > m.options.MaxSize {
+		return "", fmt.Errorf("download %s: file is larger than %d bytes", u.Path, m.options.MaxSize)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(m.options.Dir, name)); err != nil {
+		return "", err
+	}
+	return m.options.BaseURL + name, nil
+}
+
+// Handler serves the local copies at a path with a :name parameter
+func (m *Mirror) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if !fileName.MatchString(name) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		p := filepath.Join(m.options.Dir, name)
+		if _, err := os.Stat(p); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.File(p)
+	}
+}
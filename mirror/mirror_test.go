@@ -0,0 +1,157 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSave(t *testing.T) {
+	downloads := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		if r.Header.Get("Cookie") != "a=b" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png data"))
+	}))
+	defer upstream.Close()
+
+	m, err := New(Options{Dir: t.TempDir(), BaseURL: "/files/", MaxSize: 100, AllowPrivate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Cookie": {"a=b"}}
+
+	local, err := m.Save(context.Background(), upstream.URL+"/chart.png", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(local, "/files/") || !strings.HasSuffix(local, ".png") {
+		t.Errorf("unexpected local URL: %s", local)
+	}
+	// 已经下载过的文件不再下载
+	if again, err := m.Save(context.Background(), upstream.URL+"/chart.png", header); err != nil || again != local {
+		t.Errorf("expected %s, got %s, %v", local, again, err)
+	}
+	if downloads != 1 {
+		t.Errorf("expected 1 download, got %d", downloads)
+	}
+	// 没有扩展名时按 Content-Type 补上，同样只下载一次
+	for i := 0; i < 2; i++ {
+		if local, err := m.Save(context.Background(), upstream.URL+"/file?id=1", header); err != nil || !strings.HasSuffix(local, ".png") {
+			t.Errorf("unexpected local URL: %s, %v", local, err)
+		}
+	}
+	if downloads != 2 {
+		t.Errorf("expected 2 downloads, got %d", downloads)
+	}
+	if _, err := m.Save(context.Background(), upstream.URL+"/forbidden.png", nil); err == nil {
+		t.Error("expected an error for a failed download")
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/files/:name", m.Handler())
+	for path, status := range map[string]int{
+		local:                 http.StatusOK,
+		"/files/..%2Fetc.png": http.StatusNotFound,
+		"/files/0123456789abcdef0123456789abcdef.png": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Errorf("GET %s: expected %d, got %d", path, status, w.Code)
+		}
+	}
+}
+
+func TestSaveTooLarge(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 200)))
+	}))
+	defer upstream.Close()
+
+	m, err := New(Options{Dir: t.TempDir(), MaxSize: 100, AllowPrivate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Save(context.Background(), upstream.URL+"/data.csv", nil); err == nil {
+		t.Error("expected an error for a file over MaxSize")
+	}
+}
+
+func TestSavePrivateAddress(t *testing.T) {
+	downloads := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("secret"))
+	}))
+	defer upstream.Close()
+
+	m, err := New(Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{
+		upstream.URL + "/data.csv",
+		"http://localhost/data.csv",
+		"http://[::1]/data.csv",
+		"http://169.254.169.254/latest/meta-data",
+		"file:///etc/passwd",
+	} {
+		if _, err := m.Save(context.Background(), link, nil); err == nil {
+			t.Errorf("expected %s to be refused", link)
+		}
+	}
+	if downloads != 0 {
+		t.Errorf("expected no download, got %d", downloads)
+	}
+
+	// 重定向到内网地址在连接时被拒绝，重定向本身只检查 scheme
+	redirect := httptest.NewServer(http.RedirectHandler(upstream.URL+"/data.csv", http.StatusFound))
+	defer redirect.Close()
+	if _, err := m.options.Client.Get(redirect.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("expected errPrivateAddress, got %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "file:///etc/passwd", nil)
+	if err := m.options.Client.CheckRedirect(req, nil); err == nil {
+		t.Error("expected a redirect to file:// to be refused")
+	}
+	if downloads != 0 {
+		t.Errorf("expected no download, got %d", downloads)
+	}
+}
+
+func TestSaveThroughProxy(t *testing.T) {
+	// 代理本身在本机，请求的地址在代理前检查
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data of " + r.Host))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	m, err := New(Options{
+		Dir:    t.TempDir(),
+		Client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Save(context.Background(), "http://93.184.216.34/data.csv", nil); err != nil {
+		t.Errorf("expected a public address through the proxy to work, got %v", err)
+	}
+	for _, link := range []string{"http://127.0.0.1/data.csv", "http://localhost/data.csv"} {
+		if _, err := m.Save(context.Background(), link, nil); !errors.Is(err, errPrivateAddress) {
+			t.Errorf("expected %s to be refused, got %v", link, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package mockupstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"nhooyr.io/websocket"
+)
+
+const delimiter = "\x1e"
+
+func (s *Server) bingCreateConversation(c *gin.Context) {
+	if s.scenarioOf("") == ScenarioUnauthorized {
+		c.JSON(http.StatusUnauthorized, gin.H{"result": gin.H{"value": "UnauthorizedRequest", "message": "Sorry, you need to login first to access this service."}})
+		return
+	}
+	id := s.chats.Add(1)
+	c.Header("X-Sydney-Encryptedconversationsignature", fmt.Sprintf("mock-signature-%d", id))
+	c.JSON(http.StatusOK, gin.H{
+		"conversationId": fmt.Sprintf("51D|MockUpstream|%d", id),
+		"clientId":       "1055518518011297",
+		"result":         gin.H{"value": "Success", "message": nil},
+	})
+}
+
+func (s *Server) bingChatHub(c *gin.Context) {
+	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(-1)
+
+	ctx := c.Request.Context()
+	for {
+		_, v, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		for _, msg := range strings.Split(string(v), delimiter) {
+			if msg == "" {
+				continue
+			}
+			data := gjson.Parse(msg)
+			switch {
+			case data.Get("protocol").Exists():
+				if err := writeFrame(ctx, conn, "{}"); err != nil {
+					return
+				}
+			case data.Get("type").Int() == 4:
+				s.bingChat(ctx, conn, data.Get("arguments.0.message.text").String())
+				conn.Close(websocket.StatusNormalClosure, "")
+				return
+			}
+		}
+	}
+}
+
+// bingChat answers one chat invocation with SignalR frames
+func (s *Server) bingChat(ctx context.Context, conn *websocket.Conn, prompt string) {
+	switch s.scenarioOf(prompt) {
+	case ScenarioThrottle:
+		writeFrame(ctx, conn, bingResult("Throttled", "Request is throttled.", nil))
+	case ScenarioCaptcha:
+		writeFrame(ctx, conn, bingResult("CaptchaChallenge", "User needs to solve CAPTCHA to continue.", nil))
+	case ScenarioUnauthorized:
+		writeFrame(ctx, conn, bingResult("UnauthorizedRequest", "Sorry, you need to login first to access this service.", nil))
+	case ScenarioApology:
+		writeFrame(ctx, conn, bingUpdate("Sorry! That’s on me, I can’t give a response to that right now.", "Apology"))
+	case ScenarioRevoke:
+		writeFrame(ctx, conn, bingUpdate("Sure, here is", ""))
+		writeFrame(ctx, conn, bingUpdate("Sorry! That’s on me, I can’t give a response to that right now.", "Apology"))
+	default:
+		// 每次更新都是到目前为止的全文
+		var text string
+		for _, word := range words(s.reply(prompt)) {
+			text += word
+			if err := writeFrame(ctx, conn, bingUpdate(text, "")); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		writeFrame(ctx, conn, bingResult("Success", "", []any{
+			gin.H{"text": prompt, "author": "user"},
+			gin.H{"text": text, "author": "bot", "suggestedResponses": []gin.H{
+				{"text": "Tell me more."},
+				{"text": "Can you give an example?"},
+			}},
+		}))
+	}
+}
+
+func bingUpdate(text, contentOrigin string) string {
+	message := gin.H{"text": text, "author": "bot"}
+	if contentOrigin != "" {
+		message["contentOrigin"] = contentOrigin
+	}
+	v, _ := json.Marshal(gin.H{
+		"type":      1,
+		"target":    "update",
+		"arguments": []gin.H{{"messages": []gin.H{message}}},
+	})
+	return string(v)
+}
+
+func bingResult(value, message string, messages []any) string {
+	v, _ := json.Marshal(gin.H{
+		"type":         2,
+		"invocationId": "0",
+		"item": gin.H{
+			"messages": messages,
+			"result":   gin.H{"value": value, "message": message},
+		},
+	})
+	return string(v)
+}
+
+func writeFrame(ctx context.Context, conn *websocket.Conn, msg string) error {
+	return conn.Write(ctx, websocket.MessageText, []byte(msg+delimiter))
+}
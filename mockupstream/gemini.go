@@ -0,0 +1,145 @@
+package mockupstream
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/util"
+	"github.com/gin-gonic/gin"
+)
+
+// Gemini REST 使用数字表示枚举，1 是 STOP，3 是 SAFETY
+const (
+	geminiFinishStop   = 1
+	geminiFinishSafety = 3
+)
+
+// embeddingSize is the length of the vectors returned for embeddings
+const embeddingSize = 8
+
+type geminiContent struct {
+	Parts []struct {
+		Text string `json:"text"`
+	} `json:"parts"`
+}
+
+func geminiPrompt(contents []geminiContent) string {
+	var texts []string
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func geminiError(c *gin.Context, code int, status, message string) {
+	c.JSON(code, gin.H{"error": gin.H{"code": code, "message": message, "status": status}})
+}
+
+func geminiCandidate(text string, finishReason int) gin.H {
+	candidate := gin.H{
+		"content": gin.H{"parts": []gin.H{{"text": text}}, "role": "model"},
+		"index":   0,
+	}
+	if finishReason != 0 {
+		candidate["finishReason"] = finishReason
+	}
+	if finishReason == geminiFinishSafety {
+		candidate["safetyRatings"] = []gin.H{{"category": 10, "probability": 4, "blocked": true}}
+	}
+	return gin.H{"candidates": []gin.H{candidate}}
+}
+
+// gemini serves models/{model}:{method}
+func (s *Server) gemini(c *gin.Context) {
+	_, method, _ := strings.Cut(c.Param("action"), ":")
+
+	var request struct {
+		Contents []geminiContent `json:"contents"`
+		Content  geminiContent   `json:"content"`
+		Requests []struct {
+			Content geminiContent `json:"content"`
+		} `json:"requests"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		geminiError(c, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	prompt := geminiPrompt(request.Contents)
+
+	switch s.scenarioOf(prompt) {
+	case ScenarioThrottle:
+		geminiError(c, http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "Resource has been exhausted (e.g. check quota).")
+		return
+	case ScenarioUnauthorized:
+		geminiError(c, http.StatusBadRequest, "INVALID_ARGUMENT", "API key not valid. Please pass a valid API key.")
+		return
+	}
+
+	switch method {
+	case "generateContent":
+		s.geminiGenerate(c, prompt)
+	case "streamGenerateContent":
+		s.geminiStream(c, prompt)
+	case "embedContent":
+		c.JSON(http.StatusOK, gin.H{"embedding": gin.H{"values": embedding(geminiPrompt([]geminiContent{request.Content}))}})
+	case "batchEmbedContents":
+		var embeddings []gin.H
+		for _, r := range request.Requests {
+			embeddings = append(embeddings, gin.H{"values": embedding(geminiPrompt([]geminiContent{r.Content}))})
+		}
+		c.JSON(http.StatusOK, gin.H{"embeddings": embeddings})
+	default:
+		geminiError(c, http.StatusNotFound, "NOT_FOUND", "unknown method: "+method)
+	}
+}
+
+func (s *Server) geminiGenerate(c *gin.Context, prompt string) {
+	switch s.scenarioOf(prompt) {
+	case ScenarioApology, ScenarioRevoke:
+		c.JSON(http.StatusOK, geminiCandidate("", geminiFinishSafety))
+	default:
+		c.JSON(http.StatusOK, geminiCandidate(s.reply(prompt), geminiFinishStop))
+	}
+}
+
+// geminiStream writes the responses as a JSON array, one element per chunk
+func (s *Server) geminiStream(c *gin.Context, prompt string) {
+	var chunks []gin.H
+	switch s.scenarioOf(prompt) {
+	case ScenarioApology:
+		chunks = append(chunks, geminiCandidate("", geminiFinishSafety))
+	case ScenarioRevoke:
+		chunks = append(chunks, geminiCandidate("Sure, here is", 0), geminiCandidate("", geminiFinishSafety))
+	default:
+		parts := words(s.reply(prompt))
+		for i, word := range parts {
+			chunks = append(chunks, geminiCandidate(word, util.Ternary(i == len(parts)-1, geminiFinishStop, 0)))
+		}
+	}
+
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+	c.Writer.WriteString("[")
+	for i, chunk := range chunks {
+		if i > 0 {
+			c.Writer.WriteString(",\r\n")
+		}
+		v, _ := json.Marshal(chunk)
+		c.Writer.Write(v)
+		c.Writer.Flush()
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Writer.WriteString("]")
+}
+
+// embedding returns a deterministic vector derived from the text
+func embedding(text string) []float32 {
+	values := make([]float32, embeddingSize)
+	for i, r := range text {
+		values[i%embeddingSize] += float32(r%97) / 97
+	}
+	return values
+}
@@ -0,0 +1,85 @@
+package mockupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) kimiUnauthorized(c *gin.Context) {
+	c.JSON(http.StatusUnauthorized, gin.H{"error_type": "auth.token.invalid", "message": "您的授权已过期，请重新登录"})
+}
+
+func (s *Server) kimiRefreshToken(c *gin.Context) {
+	if s.scenarioOf("") == ScenarioUnauthorized {
+		s.kimiUnauthorized(c)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  "mock-access-token",
+		"refresh_token": "mock-refresh-token",
+	})
+}
+
+func (s *Server) kimiCreateChat(c *gin.Context) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	c.ShouldBindJSON(&request)
+	c.JSON(http.StatusOK, gin.H{
+		"id":         fmt.Sprintf("mockchat%012d", s.chats.Add(1)),
+		"name":       request.Name,
+		"created_at": time.Now().Format(time.RFC3339Nano),
+		"is_example": false,
+		"status":     "normal",
+		"type":       "chat",
+	})
+}
+
+func (s *Server) kimiCompletionStream(c *gin.Context) {
+	var request struct {
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var prompts []string
+	for _, message := range request.Messages {
+		prompts = append(prompts, message.Content)
+	}
+	prompt := strings.Join(prompts, "\n")
+
+	switch s.scenarioOf(prompt) {
+	case ScenarioThrottle:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error_type": "chat.rate_limit", "message": "请求过于频繁"})
+		return
+	case ScenarioUnauthorized:
+		s.kimiUnauthorized(c)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	writeEvent := func(event gin.H) {
+		v, _ := json.Marshal(event)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", v)
+		c.Writer.Flush()
+	}
+	writeEvent(gin.H{"event": "resp", "id": "mockresp", "group_id": "mockgroup"})
+	for _, word := range words(s.reply(prompt)) {
+		writeEvent(gin.H{"event": "cmpl", "text": word})
+		time.Sleep(10 * time.Millisecond)
+	}
+	writeEvent(gin.H{"event": "all_done"})
+}
+
+// 模拟的用户请求，直接返回成功
+func (s *Server) kimiOK(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,123 @@
+// Package mockupstream emulates the Bing, Kimi and Gemini APIs ollm talks to,
+// for development and integration tests without real accounts.
+package mockupstream
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scenarios a response can be scripted with
+const (
+	ScenarioOK           = "ok"
+	ScenarioThrottle     = "throttle"
+	ScenarioCaptcha      = "captcha"
+	ScenarioApology      = "apology"
+	ScenarioRevoke       = "revoke"
+	ScenarioUnauthorized = "unauthorized"
+)
+
+var scenarios = []string{ScenarioOK, ScenarioThrottle, ScenarioCaptcha, ScenarioApology, ScenarioRevoke, ScenarioUnauthorized}
+
+// 在 prompt 中写 [mock:throttle] 可以为单个请求指定场景
+var scenarioMarker = regexp.MustCompile(`\[mock:([a-z]+)]`)
+
+type Options struct {
+	// Scenario used when the prompt has no [mock:<scenario>] marker, defaults to ok
+	Scenario string
+	// Reply text, defaults to echoing the prompt
+	Reply string
+}
+
+type Server struct {
+	options  Options
+	scenario atomic.Value
+	chats    atomic.Int64
+}
+
+func New(options Options) *Server {
+	s := &Server{options: options}
+	s.scenario.Store(options.Scenario)
+	return s
+}
+
+// Handler serves all emulated upstream APIs
+func (s *Server) Handler() http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	// BING
+	r.GET("/edgesvc/turing/conversation/create", s.bingCreateConversation)
+	r.GET("/sydney/ChatHub", s.bingChatHub)
+
+	// KIMI
+	r.GET("/api/auth/token/refresh", s.kimiRefreshToken)
+	r.POST("/api/chat", s.kimiCreateChat)
+	r.POST("/api/chat/:id/completion/stream", s.kimiCompletionStream)
+	r.GET("/api/user", s.kimiOK)
+	r.GET("/api/chat_1m/user/status", s.kimiOK)
+	r.POST("/api/chat/list", s.kimiOK)
+
+	// GEMINI, e.g. /v1beta/models/gemini-pro:streamGenerateContent
+	r.POST("/v1beta/models/*action", s.gemini)
+
+	// 运行时切换默认场景
+	r.GET("/mock/scenario", s.getScenario)
+	r.PUT("/mock/scenario", s.setScenario)
+
+	return r
+}
+
+// scenarioOf returns the scenario requested by the prompt, or the default one.
+func (s *Server) scenarioOf(prompt string) string {
+	if matches := scenarioMarker.FindStringSubmatch(prompt); matches != nil {
+		return matches[1]
+	}
+	if scenario := s.scenario.Load().(string); scenario != "" {
+		return scenario
+	}
+	return ScenarioOK
+}
+
+func (s *Server) reply(prompt string) string {
+	if s.options.Reply != "" {
+		return s.options.Reply
+	}
+	prompt = strings.TrimSpace(scenarioMarker.ReplaceAllString(prompt, ""))
+	return fmt.Sprintf("This is a mock reply to: %s", prompt)
+}
+
+// words splits text into the chunks a streaming upstream would send
+func words(text string) []string {
+	return strings.SplitAfter(text, " ")
+}
+
+func (s *Server) getScenario(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"scenario": s.scenarioOf(""), "scenarios": scenarios})
+}
+
+func (s *Server) setScenario(c *gin.Context) {
+	var request struct {
+		Scenario string `json:"scenario"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	valid := false
+	for _, scenario := range scenarios {
+		valid = valid || scenario == request.Scenario
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scenario: " + request.Scenario})
+		return
+	}
+	s.scenario.Store(request.Scenario)
+	c.JSON(http.StatusOK, gin.H{"scenario": request.Scenario})
+}
@@ -0,0 +1,148 @@
+package mockupstream
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cphovo/ollm/gemini"
+	"github.com/cphovo/ollm/kimi"
+	"github.com/cphovo/ollm/sydney"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(New(Options{}).Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// chdirTemp runs the test in a temporary directory, so the cookies.json
+// written by the Bing client does not end up in the package
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestBing(t *testing.T) {
+	chdirTemp(t)
+	server := newTestServer(t)
+
+	tests := []struct {
+		prompt string
+		text   string
+		err    string
+	}{
+		{"hello", "This is a mock reply to: hello", ""},
+		{"[mock:throttle] hello", "", "Throttled"},
+		{"[mock:revoke] hello", "Sure, here is", "Message revoke detected"},
+		{"[mock:apology] hello", "", "triggered the Bing filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prompt, func(t *testing.T) {
+			sydneyAPI, err := sydney.NewSydney(sydney.Options{
+				Endpoints: sydney.Endpoints{
+					ChatHub:            "ws" + strings.TrimPrefix(server.URL, "http") + "/sydney/ChatHub",
+					CreateConversation: server.URL + "/edgesvc/turing/conversation/create",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			messageCh, err := sydneyAPI.AskStream(sydney.AskStreamOptions{
+				StopCtx: context.Background(),
+				Prompt:  tt.prompt,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var text, errText string
+			for message := range messageCh {
+				switch message.Type {
+				case sydney.MessageTypeMessageText:
+					text += message.Text
+				case sydney.MessageTypeError:
+					errText = message.Text
+				}
+			}
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if !strings.Contains(errText, tt.err) || (tt.err == "" && errText != "") {
+				t.Errorf("error = %q, want %q", errText, tt.err)
+			}
+		})
+	}
+}
+
+func TestKimi(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	k, err := kimi.NewKimi(ctx, "mock-refresh-token", kimi.Endpoints{Base: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	convId, err := k.CreateChat(ctx, "未命名会话")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := k.AskStream(ctx, kimi.AskStreamOptions{Text: "hello", ConvId: convId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	for message := range messages {
+		if message.Event == "cmpl" {
+			text += message.Text
+		}
+	}
+	if text != "This is a mock reply to: hello" {
+		t.Errorf("text = %q", text)
+	}
+
+	_, err = k.AskStream(ctx, kimi.AskStreamOptions{Text: "[mock:throttle] hello", ConvId: convId})
+	if err == nil || !strings.Contains(err.Error(), "status code: 429") {
+		t.Errorf("err = %v, want status code 429", err)
+	}
+}
+
+func TestGemini(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	messageCh, err := gemini.AskStream(ctx, gemini.AskStreamOptions{APIKey: "mock", Model: "gemini-pro", Prompt: "hello", Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	for message := range messageCh {
+		if message.Error != nil {
+			t.Fatal(message.Error)
+		}
+		text += message.Text
+	}
+	if text != "This is a mock reply to: hello" {
+		t.Errorf("text = %q", text)
+	}
+
+	_, err = gemini.Ask(ctx, gemini.AskStreamOptions{APIKey: "mock", Model: "gemini-pro", Prompt: "[mock:throttle] hello", Endpoint: server.URL})
+	if !gemini.IsQuotaError(err) {
+		t.Errorf("err = %v, want quota error", err)
+	}
+
+	embeddings, err := gemini.Embed(ctx, gemini.EmbedOptions{APIKey: "mock", Model: "text-embedding-004", Input: []string{"a", "b"}, Endpoint: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embeddings) != 2 || len(embeddings[0]) != embeddingSize {
+		t.Errorf("embeddings = %v", embeddings)
+	}
+}
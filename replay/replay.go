@@ -0,0 +1,210 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cphovo/ollm/audit"
+)
+
+// ErrExhausted is returned when the upstream asks for more than the fixture recorded
+var ErrExhausted = errors.New("replay: fixture exhausted")
+
+// Interaction is one recorded HTTP exchange. Request headers and the query
+// string are not recorded so that credentials never end up in a fixture.
+type Interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// Fixture is the file format of a recording. Frames are the raw websocket
+// messages received from the server, e.g. "\x1e" delimited SignalR messages.
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+	Frames       []string      `json:"frames,omitempty"`
+}
+
+// Session records upstream traffic into a fixture or serves a fixture back.
+type Session struct {
+	mu        sync.Mutex
+	path      string
+	recording bool
+	fixture   Fixture
+	redactor  *audit.Redactor
+
+	interactions int
+	frames       int
+}
+
+// NewRecorder returns a session that records into path when Save is called.
+// Credentials in recorded bodies and headers are redacted.
+func NewRecorder(path string) *Session {
+	redactor, err := audit.NewRedactor(nil)
+	if err != nil {
+		panic(err)
+	}
+	return &Session{path: path, recording: true, redactor: redactor}
+}
+
+// Open loads a fixture for replay.
+func Open(path string) (*Session, error) {
+	v, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{path: path}
+	if err := json.Unmarshal(v, &s.fixture); err != nil {
+		return nil, fmt.Errorf("failed to json.Unmarshal content of replay fixture %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Start opens path for replay, or records into it when REPLAY_RECORD=true so
+// that fixtures can be refreshed against the live services.
+func Start(path string) (*Session, error) {
+	if os.Getenv("REPLAY_RECORD") == "true" {
+		return NewRecorder(path), nil
+	}
+	return Open(path)
+}
+
+func (s *Session) Recording() bool {
+	return s.recording
+}
+
+// Save writes the recorded fixture, it does nothing in replay mode.
+func (s *Session) Save() error {
+	if !s.recording {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := json.MarshalIndent(&s.fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(v, '\n'), 0o644)
+}
+
+// Transport wraps base. Recording sessions forward requests to base and keep
+// the responses, replaying sessions answer from the fixture in order.
+func (s *Session) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{session: s, base: base}
+}
+
+// RecordFrame keeps a websocket message received from the server.
+func (s *Session) RecordFrame(frame string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixture.Frames = append(s.fixture.Frames, s.redactor.Redact(frame))
+}
+
+// NextFrame returns the next recorded websocket message.
+func (s *Session) NextFrame() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frames >= len(s.fixture.Frames) {
+		return "", ErrExhausted
+	}
+	s.frames++
+	return s.fixture.Frames[s.frames-1], nil
+}
+
+func recordedURL(req *http.Request) string {
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
+
+type transport struct {
+	session *Session
+	base    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.session.recording {
+		return t.record(req)
+	}
+	return t.replay(req)
+}
+
+func (t *transport) record(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// 流式响应会被读完后再返回，录制时失去流式效果
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	s := t.session
+	header := http.Header{}
+	for k, values := range resp.Header {
+		for _, v := range values {
+			header.Add(k, s.redactor.Redact(v))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixture.Interactions = append(s.fixture.Interactions, Interaction{
+		Method: req.Method,
+		URL:    recordedURL(req),
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   s.redactor.Redact(string(body)),
+	})
+	return resp, nil
+}
+
+func (t *transport) replay(req *http.Request) (*http.Response, error) {
+	s := t.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.interactions >= len(s.fixture.Interactions) {
+		return nil, fmt.Errorf("%w: unexpected %s %s", ErrExhausted, req.Method, recordedURL(req))
+	}
+	interaction := s.fixture.Interactions[s.interactions]
+	if interaction.Method != req.Method || interaction.URL != recordedURL(req) {
+		return nil, fmt.Errorf("replay: expected %s %s, got %s %s",
+			interaction.Method, interaction.URL, req.Method, recordedURL(req))
+	}
+	s.interactions++
+
+	header := interaction.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(interaction.Body)),
+		ContentLength: int64(len(interaction.Body)),
+		Request:       req,
+	}, nil
+}
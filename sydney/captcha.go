@@ -2,16 +2,20 @@ package sydney
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/cphovo/ollm/sydney/internal/hex"
+	upperhex "github.com/cphovo/ollm/sydney/internal/hex"
 	"github.com/cphovo/ollm/util"
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
@@ -20,46 +24,116 @@ import (
 	"github.com/google/uuid"
 )
 
-func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
+// CaptchaChallenge is what a solver gets to pass the CAPTCHA of an account
+type CaptchaChallenge struct {
+	// Cookies of the account, must not be modified
+	Cookies        map[string]string
+	ConversationID string
+	MessageID      string
+	// Optional, e.g. local-gen-<uuid>
+	IFrameID string
+	// Bing endpoint, e.g. https://www.bing.com
+	Bing  string
+	Proxy string
+}
+
+// account identifies the account of the challenge without exposing its cookies
+func (c CaptchaChallenge) account() string {
+	credential := c.Cookies["_U"]
+	if credential == "" {
+		names := make([]string, 0, len(c.Cookies))
+		for name := range c.Cookies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			credential += name + "=" + c.Cookies[name] + ";"
+		}
+	}
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (c CaptchaChallenge) challengeURL() string {
+	iframeID := util.Ternary(c.IFrameID == "", "local-gen-"+uuid.New().String(), c.IFrameID)
+	return c.Bing + "/turing/captcha/challenge?q=&iframeid=" + iframeID
+}
+
+// cookieDomain is the host of the Bing endpoint, so that the account cookies
+// are sent to mirrors and test servers as well.
+func (c CaptchaChallenge) cookieDomain() string {
+	u, err := url.Parse(c.Bing)
+	if err != nil || u.Hostname() == "" {
+		return ".bing.com"
+	}
+	return u.Hostname()
+}
+
+// CaptchaSolver passes a Bing CAPTCHA and returns the cookies to apply,
+// which must contain cct.
+type CaptchaSolver interface {
+	Name() string
+	Solve(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error)
+}
+
+// BrowserSolver opens the challenge in a local Chrome controlled by rod.
+// With Headless false a human can click the challenge if it is not passed automatically.
+type BrowserSolver struct {
+	Headless bool
+	// Defaults to 60s
+	Timeout time.Duration
+}
+
+func (s BrowserSolver) Name() string {
+	return "browser"
+}
+
+func (s BrowserSolver) Solve(stopCtx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	cookies, _, err := s.SolveWithScreenshot(stopCtx, challenge)
+	return cookies, err
+}
+
+// SolveWithScreenshot is Solve also returning a PNG screenshot of the
+// challenge page, taken when it was passed or timed out.
+func (s BrowserSolver) SolveWithScreenshot(stopCtx context.Context, challenge CaptchaChallenge) (resCookies map[string]string, screenshot []byte, err error) {
 	defer func() {
 		if err0 := recover(); err0 != nil {
 			slog.Warn("Error resolving captcha", "err", err0)
-			err = err0.(error)
+			err = fmt.Errorf("%v", err0)
 		}
 	}()
-	iframeID := uuid.New().String()
 	l := launcher.NewUserMode().Context(stopCtx).
 		Leakless(true).
 		UserDataDir(filepath.Join(os.TempDir(), "rod-user-data-"+uuid.New().String())).
 		Set("disable-default-apps").
-		Set("no-first-run").Headless(false)
+		Set("no-first-run").Headless(s.Headless)
 	defer l.Cleanup()
 	u := l.MustLaunch()
 	browser := rod.New().Context(stopCtx).NoDefaultDevice().ControlURL(u).MustConnect()
 	defer browser.MustClose()
 	var cookies []*proto.NetworkCookie
-	for k, v := range o.cookies {
+	for k, v := range challenge.Cookies {
 		cookies = append(cookies, &proto.NetworkCookie{
 			Name:    k,
 			Value:   v,
-			Domain:  ".bing.com",
+			Domain:  challenge.cookieDomain(),
 			Path:    "/",
 			Expires: proto.TimeSinceEpoch(time.Now().Add(1 * time.Hour).Unix()),
 		})
 	}
 	browser.MustSetCookies(cookies...)
 	page := stealth.MustPage(browser)
-	page.MustNavigate("https://www.bing.com/turing/captcha/challenge?" +
-		"q=&iframeid=local-gen-" + iframeID)
+	page.MustNavigate(challenge.challengeURL())
 	page.MustElement("body")
-	page.MustEval("()=>{let info=document.createElement('h3');" +
-		"info.textContent='↑ Please help click if this cannot be processed automatically!';" +
-		"document.body.appendChild(info);}")
+	if !s.Headless {
+		page.MustEval("()=>{let info=document.createElement('h3');" +
+			"info.textContent='↑ Please help click if this cannot be processed automatically!';" +
+			"document.body.appendChild(info);}")
+	}
 	router := page.HijackRequests()
 	waitCh := make(chan struct{}, 16)
 	defer close(waitCh)
-	var resCookies map[string]string
-	router.MustAdd("https://www.bing.com/challenge/verify*", func(hijack *rod.Hijack) {
+	router.MustAdd(challenge.Bing+"/challenge/verify*", func(hijack *rod.Hijack) {
 		hijack.MustLoadResponse()
 		for key, values := range hijack.Response.Headers() {
 			if strings.ToLower(key) != "set-cookie" {
@@ -76,59 +150,84 @@ func (o *Sydney) ResolveCaptcha(stopCtx context.Context) (err error) {
 	go router.Run()
 	defer router.Stop()
 
-	ticker := time.NewTicker(60 * time.Second)
+	ticker := time.NewTicker(util.Ternary(s.Timeout == 0, 60*time.Second, s.Timeout))
 	defer ticker.Stop()
 
 	select {
-	// case <-time.Tick(60 * time.Second):
 	case <-ticker.C:
-		return errors.New("timeout verifying challenge token")
+		return nil, page.MustScreenshot(), ErrCaptchaTimeout
 	case <-stopCtx.Done():
-		return stopCtx.Err()
+		return nil, nil, stopCtx.Err()
 	case <-waitCh:
 	}
 	slog.Info("Captcha resCookies", "v", resCookies)
-	if err := o.postprocessCaptchaCookies(resCookies); err != nil {
-		return err
-	}
-	return nil
+	return resCookies, page.MustScreenshot(), nil
+}
+
+// BypassServerSolver sends the challenge to a SydneyQt compatible bypass server
+type BypassServerSolver struct {
+	URL string
+}
+
+func (s BypassServerSolver) Name() string {
+	return "bypass_server"
 }
-func (o *Sydney) BypassCaptcha(stopCtx context.Context, conversationID string, messageID string) error {
-	if o.bypassServer == "" {
-		return errors.New("no bypass server specified")
+
+func (s BypassServerSolver) Solve(stopCtx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	if s.URL == "" {
+		return nil, errors.New("no bypass server specified")
 	}
-	_, client, err := util.MakeHTTPClient(o.proxy, 60*time.Second)
+	_, client, err := util.MakeHTTPClient(challenge.Proxy, 60*time.Second)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req := BypassCaptchaRequest{
-		IG:       hex.NewUpperHex(32),
-		Cookies:  util.FormatCookieString(o.cookies),
+		IG:       upperhex.NewUpperHex(32),
+		Cookies:  util.FormatCookieString(challenge.Cookies),
 		IFrameID: "local-gen-" + uuid.New().String(),
-		ConvID:   conversationID,
-		RID:      messageID,
+		ConvID:   challenge.ConversationID,
+		RID:      challenge.MessageID,
 	}
 	slog.Debug("Bypass CAPTCHA request", "v", req)
-	resp, err := client.R().SetContext(stopCtx).SetBody(req).Post(o.bypassServer)
+	resp, err := client.R().SetContext(stopCtx).SetBody(req).Post(s.URL)
 	if err != nil {
-		return fmt.Errorf("cannot communicate with captcha bypass server: %w", err)
+		return nil, fmt.Errorf("cannot communicate with captcha bypass server: %w", err)
 	}
 	slog.Debug("Bypass captcha response body", "v", resp.String())
 	var response BypassCaptchaResponse
 	err = json.Unmarshal(resp.Bytes(), &response)
 	if err != nil {
-		return fmt.Errorf("cannot unmarshal json from captcha bypass server: %w", err)
+		return nil, fmt.Errorf("cannot unmarshal json from captcha bypass server: %w", err)
 	}
 	if response.Error != "" {
-		return errors.New("bypass captcha error: " + response.Error)
+		return nil, errors.New("bypass captcha error: " + response.Error)
 	}
 	cookies := util.ParseCookiesFromString(response.Result.Cookies)
-	if err := o.postprocessCaptchaCookies(cookies); err != nil {
-		return fmt.Errorf("%w; screenshot: "+
-			strings.TrimSuffix(o.bypassServer, "/")+
-			response.Result.ScreenShot, err)
+	if _, ok := cookies["cct"]; !ok {
+		return nil, fmt.Errorf("%w; screenshot: "+
+			strings.TrimSuffix(s.URL, "/")+
+			response.Result.ScreenShot, errInvalidCaptchaCookies)
 	}
-	return nil
+	return cookies, nil
+}
+
+var ErrCaptchaTimeout = errors.New("timeout verifying challenge token")
+
+var errInvalidCaptchaCookies = errors.New("captcha cookies not valid: no cookie named cct found")
+
+// solveCaptcha passes the CAPTCHA with the configured solver and applies the new cookies
+func (o *Sydney) solveCaptcha(stopCtx context.Context, conversationID string, messageID string) error {
+	cookies, err := o.captchaSolver.Solve(stopCtx, CaptchaChallenge{
+		Cookies:        util.CopyMap(o.cookies),
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Bing:           o.endpoints.Bing,
+		Proxy:          o.proxy,
+	})
+	if err != nil {
+		return err
+	}
+	return o.postprocessCaptchaCookies(cookies)
 }
 func (o *Sydney) UpdateModifiedCookies(modifiedCookies map[string]string) {
 	for k, v := range modifiedCookies { // keep the map pointer
@@ -139,10 +238,22 @@ func (o *Sydney) UpdateModifiedCookies(modifiedCookies map[string]string) {
 		slog.Warn("Cannot update cookies file: ", "err", err)
 	}
 }
+
+// accountCookies identify the account, a solved CAPTCHA never replaces them
+var accountCookies = []string{"_U", "KievRPSSecAuth", "_RwBf"}
+
 func (o *Sydney) postprocessCaptchaCookies(modifiedCookies map[string]string) error {
 	if _, ok := modifiedCookies["cct"]; !ok {
-		return errors.New("captcha cookies not valid: no cookie named cct found")
+		return errInvalidCaptchaCookies
+	}
+	// 只保留 /challenge/verify 设置的 cookies，账号 cookies 不能被替换
+	captchaCookies := util.CopyMap(modifiedCookies)
+	for _, name := range accountCookies {
+		if _, ok := captchaCookies[name]; ok {
+			slog.Warn("Ignoring account cookie returned by the captcha solver", "name", name)
+			delete(captchaCookies, name)
+		}
 	}
-	o.UpdateModifiedCookies(modifiedCookies)
+	o.UpdateModifiedCookies(captchaCookies)
 	return nil
 }
@@ -0,0 +1,168 @@
+package sydney
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrCaptchaNotFound = errors.New("captcha not found or already solved")
+
+// PendingCaptcha is a challenge waiting for an admin
+type PendingCaptcha struct {
+	ID string `json:"id"`
+	// Hash of the account cookies
+	Account        string `json:"account"`
+	ConversationID string `json:"conversationId"`
+	// Open it in a browser logged in with the account to solve the challenge
+	ChallengeURL string    `json:"challengeUrl"`
+	CreatedAt    time.Time `json:"createdAt"`
+	// Requests waiting for this challenge
+	Waiting int `json:"waiting"`
+}
+
+type pendingCaptcha struct {
+	PendingCaptcha
+	done    chan struct{}
+	cookies map[string]string
+}
+
+// ManualSolver queues the challenges until an admin solves them and pastes
+// the cct cookies with Resolve. Requests of the same account wait for the
+// same challenge.
+type ManualSolver struct {
+	timeout   time.Duration
+	notifyURL string
+
+	mu      sync.Mutex
+	pending map[string]*pendingCaptcha
+}
+
+// NewManualSolver creates a ManualSolver, notifyURL (optional) receives a
+// POST with the PendingCaptcha when a new challenge is queued.
+func NewManualSolver(timeout time.Duration, notifyURL string) *ManualSolver {
+	return &ManualSolver{
+		timeout:   timeout,
+		notifyURL: notifyURL,
+		pending:   map[string]*pendingCaptcha{},
+	}
+}
+
+func (s *ManualSolver) Name() string {
+	return "manual"
+}
+
+func (s *ManualSolver) Solve(ctx context.Context, challenge CaptchaChallenge) (map[string]string, error) {
+	account := challenge.account()
+
+	s.mu.Lock()
+	var captcha *pendingCaptcha
+	for _, p := range s.pending {
+		if p.Account == account {
+			captcha = p
+			break
+		}
+	}
+	if captcha == nil {
+		id := uuid.New().String()
+		captcha = &pendingCaptcha{
+			PendingCaptcha: PendingCaptcha{
+				ID:             id,
+				Account:        account,
+				ConversationID: challenge.ConversationID,
+				ChallengeURL:   challenge.challengeURL(),
+				CreatedAt:      time.Now(),
+			},
+			done: make(chan struct{}),
+		}
+		s.pending[id] = captcha
+		slog.Warn("CAPTCHA waiting for an admin", "id", id, "account", account, "url", captcha.ChallengeURL)
+		go s.notify(captcha.PendingCaptcha)
+	}
+	captcha.Waiting++
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-captcha.done:
+		return captcha.cookies, nil
+	case <-timer.C:
+		s.leave(captcha)
+		return nil, errors.New("timeout waiting for an admin to solve the captcha")
+	case <-ctx.Done():
+		s.leave(captcha)
+		return nil, ctx.Err()
+	}
+}
+
+// leave removes the challenge when its last request stops waiting
+func (s *ManualSolver) leave(captcha *pendingCaptcha) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	captcha.Waiting--
+	if captcha.Waiting == 0 {
+		delete(s.pending, captcha.ID)
+	}
+}
+
+func (s *ManualSolver) notify(captcha PendingCaptcha) {
+	if s.notifyURL == "" {
+		return
+	}
+	v, _ := json.Marshal(captcha)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.notifyURL, bytes.NewReader(v))
+	if err != nil {
+		slog.Warn("Cannot notify captcha", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Warn("Cannot notify captcha", "err", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// Pending lists the challenges waiting for an admin, oldest first
+func (s *ManualSolver) Pending() []PendingCaptcha {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]PendingCaptcha, 0, len(s.pending))
+	for _, p := range s.pending {
+		res = append(res, p.PendingCaptcha)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res
+}
+
+// Resolve hands the cct cookie to every request waiting for the challenge,
+// the other pasted cookies are ignored.
+func (s *ManualSolver) Resolve(id string, cookies map[string]string) error {
+	cct, ok := cookies["cct"]
+	if !ok {
+		return errInvalidCaptchaCookies
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	captcha, ok := s.pending[id]
+	if !ok {
+		return ErrCaptchaNotFound
+	}
+	delete(s.pending, id)
+	captcha.cookies = map[string]string{"cct": cct}
+	close(captcha.done)
+	return nil
+}
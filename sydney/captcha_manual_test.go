@@ -0,0 +1,92 @@
+package sydney
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManualSolver(t *testing.T) {
+	solver := NewManualSolver(time.Second, "")
+	challenge := CaptchaChallenge{Cookies: map[string]string{"_U": "test"}, Bing: "https://www.bing.com"}
+
+	results := make(chan map[string]string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			cookies, err := solver.Solve(context.Background(), challenge)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- cookies
+		}()
+	}
+
+	// 同一个账号的请求共用一个验证码
+	var pending []PendingCaptcha
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		pending = solver.Pending()
+		if len(pending) == 1 && pending[0].Waiting == 2 {
+			break
+		}
+	}
+	if len(pending) != 1 || pending[0].Waiting != 2 {
+		t.Fatalf("pending = %+v, want one captcha with two waiting requests", pending)
+	}
+
+	if err := solver.Resolve(pending[0].ID, map[string]string{"_U": "test"}); !errors.Is(err, errInvalidCaptchaCookies) {
+		t.Errorf("Resolve without cct: err = %v", err)
+	}
+	// 只有 cct 会交给等待的请求
+	if err := solver.Resolve(pending[0].ID, map[string]string{"cct": "solved", "_U": "other"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if cookies := <-results; len(cookies) != 1 || cookies["cct"] != "solved" {
+			t.Errorf("cookies = %v", cookies)
+		}
+	}
+	if err := solver.Resolve(pending[0].ID, map[string]string{"cct": "solved"}); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("second Resolve: err = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := solver.Solve(ctx, challenge); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Solve: err = %v", err)
+	}
+	if pending := solver.Pending(); len(pending) != 0 {
+		t.Errorf("pending after cancel = %+v", pending)
+	}
+}
+
+func TestPostprocessCaptchaCookies(t *testing.T) {
+	chdirTemp(t)
+	sydney, err := NewSydney(Options{Cookies: map[string]string{"_U": "account"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sydney.postprocessCaptchaCookies(map[string]string{"_U": "other"}); !errors.Is(err, errInvalidCaptchaCookies) {
+		t.Errorf("without cct: err = %v", err)
+	}
+	if err := sydney.postprocessCaptchaCookies(map[string]string{"cct": "solved", "_U": "other", "MUID": "m"}); err != nil {
+		t.Fatal(err)
+	}
+	if sydney.cookies["_U"] != "account" || sydney.cookies["cct"] != "solved" || sydney.cookies["MUID"] != "m" {
+		t.Errorf("cookies = %v", sydney.cookies)
+	}
+}
+
+func TestChallengeCookieDomain(t *testing.T) {
+	for bing, want := range map[string]string{
+		"https://www.bing.com":       "www.bing.com",
+		"https://cn.bing.com":        "cn.bing.com",
+		"http://127.0.0.1:8081":      "127.0.0.1",
+		"https://bing.example.com/x": "bing.example.com",
+		"":                           ".bing.com",
+	} {
+		if got := (CaptchaChallenge{Bing: bing}).cookieDomain(); got != want {
+			t.Errorf("%q: domain = %q, want %q", bing, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package sydney
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// CitationFormatFootnote keeps the [^1^] markers of Bing
+	CitationFormatFootnote = "footnote"
+	// CitationFormatMarkdown renders the markers as [1](url)
+	CitationFormatMarkdown = "markdown"
+)
+
+// Annotation is an OpenAI url_citation annotation of a message
+type Annotation struct {
+	Type        string      `json:"type"`
+	URLCitation URLCitation `json:"url_citation"`
+}
+
+type URLCitation struct {
+	// Number of the footnote in the text
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// Range of the citation in the content, in characters
+	StartIndex int `json:"start_index"`
+	EndIndex   int `json:"end_index"`
+}
+
+// [^1^] 或者 [1](url)
+var citationMarker = regexp.MustCompile(`\[\^(\d+)\^]|\[(\d+)]\([^)\s]+\)`)
+
+// 流式输出时，片段末尾可能是不完整的脚注
+var partialFootnote = regexp.MustCompile(`\[(\^(\d+(\^)?)?)?$`)
+
+// NewAnnotations returns one url_citation per citation marker of the content
+func NewAnnotations(content string, sources []SourceAttribute) []Annotation {
+	byIndex := map[int]SourceAttribute{}
+	for _, source := range sources {
+		byIndex[source.Index] = source
+	}
+	var annotations []Annotation
+	for _, match := range citationMarker.FindAllStringSubmatchIndex(content, -1) {
+		var number string
+		if match[2] >= 0 {
+			number = content[match[2]:match[3]]
+		} else {
+			number = content[match[4]:match[5]]
+		}
+		index, _ := strconv.Atoi(number)
+		source, ok := byIndex[index]
+		if !ok {
+			continue
+		}
+		start := utf8.RuneCountInString(content[:match[0]])
+		annotations = append(annotations, Annotation{
+			Type: "url_citation",
+			URLCitation: URLCitation{
+				Index:      index,
+				Title:      source.Title,
+				URL:        source.Link,
+				StartIndex: start,
+				EndIndex:   start + utf8.RuneCountInString(content[match[0]:match[1]]),
+			},
+		})
+	}
+	return annotations
+}
+
+// CitationRenderer replaces the [^1^] footnotes of a streamed answer with
+// Markdown links. A footnote split across deltas is held back until it is complete.
+type CitationRenderer struct {
+	sources map[int]SourceAttribute
+	pending string
+}
+
+func NewCitationRenderer() *CitationRenderer {
+	return &CitationRenderer{sources: map[int]SourceAttribute{}}
+}
+
+func (r *CitationRenderer) AddSources(sources []SourceAttribute) {
+	for _, source := range sources {
+		r.sources[source.Index] = source
+	}
+}
+
+// Write returns the rendered text that can be sent for the delta
+func (r *CitationRenderer) Write(delta string) string {
+	text := r.pending + delta
+	r.pending = ""
+	if loc := partialFootnote.FindStringIndex(text); loc != nil {
+		r.pending = text[loc[0]:]
+		text = text[:loc[0]]
+	}
+	return r.render(text)
+}
+
+// Flush returns the text held back at the end of the answer
+func (r *CitationRenderer) Flush() string {
+	text := r.render(r.pending)
+	r.pending = ""
+	return text
+}
+
+func (r *CitationRenderer) render(text string) string {
+	return citationMarker.ReplaceAllStringFunc(text, func(marker string) string {
+		if !strings.HasPrefix(marker, "[^") {
+			return marker
+		}
+		index, _ := strconv.Atoi(strings.Trim(marker, "[^]"))
+		source, ok := r.sources[index]
+		if !ok {
+			return marker
+		}
+		link := strings.NewReplacer("(", "%28", ")", "%29", " ", "%20").Replace(source.Link)
+		return fmt.Sprintf("[%d](%s)", index, link)
+	})
+}
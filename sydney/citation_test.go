@@ -0,0 +1,40 @@
+package sydney
+
+import "testing"
+
+var testSources = []SourceAttribute{
+	{Index: 1, Link: "https://example.com/a", Title: "A"},
+	{Index: 2, Link: "https://example.com/b (2)", Title: "B"},
+}
+
+func TestNewAnnotations(t *testing.T) {
+	content := "你好[^1^] world [2](https://example.com/b) [^3^]"
+	annotations := NewAnnotations(content, testSources)
+	if len(annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %d", len(annotations))
+	}
+	first := annotations[0].URLCitation
+	if first.Index != 1 || first.URL != "https://example.com/a" || first.StartIndex != 2 || first.EndIndex != 7 {
+		t.Errorf("unexpected first annotation: %+v", first)
+	}
+	second := annotations[1].URLCitation
+	if second.Index != 2 || second.StartIndex != 14 || second.Title != "B" {
+		t.Errorf("unexpected second annotation: %+v", second)
+	}
+}
+
+func TestCitationRenderer(t *testing.T) {
+	r := NewCitationRenderer()
+	r.AddSources(testSources)
+
+	var out string
+	for _, delta := range []string{"foo [", "^1", "^] bar [^2^]", " [^9^] [^"} {
+		out += r.Write(delta)
+	}
+	out += r.Flush()
+
+	expected := "foo [1](https://example.com/a) bar [2](https://example.com/b%20%282%29) [^9^] [^"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
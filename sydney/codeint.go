@@ -0,0 +1,123 @@
+package sydney
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// GeneratedFile is a chart or file produced by the code interpreter
+type GeneratedFile struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	MimeType string `json:"mimeType,omitempty"`
+	// Path of the local copy, set when the files are mirrored
+	LocalURL string `json:"localUrl,omitempty"`
+}
+
+func (f GeneratedFile) IsImage() bool {
+	return strings.HasPrefix(f.MimeType, "image/")
+}
+
+// CodeInterpreterRun is the code run by the code interpreter and its output
+type CodeInterpreterRun struct {
+	Code   string `json:"code"`
+	Output string `json:"output,omitempty"`
+}
+
+// ContentPart is an image or a file of the answer, in the format of the OpenAI image_url parts
+type ContentPart struct {
+	// image_url or file
+	Type     string           `json:"type"`
+	ImageURL *ContentImageURL `json:"image_url,omitempty"`
+	File     *GeneratedFile   `json:"file,omitempty"`
+}
+
+type ContentImageURL struct {
+	URL string `json:"url"`
+}
+
+// NewContentParts returns an image_url part for each image and a file part for the others
+func NewContentParts(files []GeneratedFile) []ContentPart {
+	var parts []ContentPart
+	for _, file := range files {
+		if file.IsImage() {
+			parts = append(parts, ContentPart{
+				Type:     "image_url",
+				ImageURL: &ContentImageURL{URL: file.URL},
+				File:     &file,
+			})
+			continue
+		}
+		parts = append(parts, ContentPart{Type: "file", File: &file})
+	}
+	return parts
+}
+
+// ![chart](https://...) 或者 [data.csv](https://...)
+var markdownLink = regexp.MustCompile(`(!?)\[([^\]]*)]\((https?://[^)\s]+)\)`)
+
+// IsBingURL reports whether the link points to Bing or to the configured Bing endpoint
+func IsBingURL(rawURL, bingEndpoint string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if bing, err := url.Parse(bingEndpoint); err == nil && bing.Hostname() != "" && host == strings.ToLower(bing.Hostname()) {
+		return true
+	}
+	return host == "bing.com" || strings.HasSuffix(host, ".bing.com")
+}
+
+// codeInterpreterFiles finds the images of the adaptive cards and the links of the text of a message.
+// The text can be steered by the prompt, so only the links to Bing are kept.
+func codeInterpreterFiles(message gjson.Result, bingEndpoint string) []GeneratedFile {
+	var files []GeneratedFile
+	var walk func(value gjson.Result)
+	walk = func(value gjson.Result) {
+		if value.IsArray() {
+			for _, item := range value.Array() {
+				walk(item)
+			}
+			return
+		}
+		if !value.IsObject() {
+			return
+		}
+		if value.Get("type").String() == "Image" && IsBingURL(value.Get("url").String(), bingEndpoint) {
+			files = append(files, newGeneratedFile(value.Get("altText").String(), value.Get("url").String(), true))
+		}
+		value.ForEach(func(_, child gjson.Result) bool {
+			walk(child)
+			return true
+		})
+	}
+	walk(message.Get("adaptiveCards"))
+
+	for _, match := range markdownLink.FindAllStringSubmatch(message.Get("text").String(), -1) {
+		if !IsBingURL(match[3], bingEndpoint) {
+			continue
+		}
+		files = append(files, newGeneratedFile(match[2], match[3], match[1] == "!"))
+	}
+	return files
+}
+
+func newGeneratedFile(name, link string, image bool) GeneratedFile {
+	file := GeneratedFile{Name: name, URL: link}
+	if u, err := url.Parse(link); err == nil {
+		if file.Name == "" {
+			file.Name = path.Base(u.Path)
+		}
+		file.MimeType = mime.TypeByExtension(path.Ext(u.Path))
+	}
+	if image && !file.IsImage() {
+		file.MimeType = "image/png"
+	}
+	return file
+}
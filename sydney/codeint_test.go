@@ -0,0 +1,35 @@
+package sydney
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCodeInterpreterFiles(t *testing.T) {
+	message := gjson.Parse(`{
+		"text": "Saved as [data.csv](https://www.bing.com/codeint/file?id=1&name=data.csv) and ![plot](https://www.bing.com/codeint/plot), see [metadata](http://169.254.169.254/latest) and [evil](https://bing.com.evil.example/x.png)",
+		"adaptiveCards": [{"body": [{"type": "Container", "items": [
+			{"type": "Image", "url": "https://www.bing.com/th?id=chart.png", "altText": "chart"},
+			{"type": "Image", "url": "http://127.0.0.1/internal.png"}
+		]}]}]
+	}`)
+	files := codeInterpreterFiles(message, "https://www.bing.com")
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %+v", files)
+	}
+	if files[0].Name != "chart" || !files[0].IsImage() {
+		t.Errorf("unexpected card image: %+v", files[0])
+	}
+	if files[1].Name != "data.csv" || files[1].IsImage() {
+		t.Errorf("unexpected file link: %+v", files[1])
+	}
+	if !files[2].IsImage() {
+		t.Errorf("unexpected image link: %+v", files[2])
+	}
+
+	parts := NewContentParts(files)
+	if parts[0].Type != "image_url" || parts[0].ImageURL.URL != files[0].URL || parts[1].Type != "file" {
+		t.Errorf("unexpected content parts: %+v", parts)
+	}
+}
@@ -17,8 +17,11 @@ func (o *Sydney) createConversation() (CreateConversationResponse, error) {
 	if err != nil {
 		return empty, err
 	}
+	if o.replay != nil {
+		client.GetClient().Transport = o.replay.Transport(client.GetClient().Transport)
+	}
 	resp, err := client.R().SetHeader("Accept", "application/json").
-		SetHeader("Cookie", util.FormatCookieString(o.cookies)).Get(o.createConversationURL)
+		SetHeader("Cookie", util.FormatCookieString(o.cookies)).Get(o.endpoints.CreateConversation)
 	if err != nil {
 		return empty, err
 	}
@@ -23,7 +23,7 @@ func (o *Sydney) GetUser() (string, error) {
 	}
 	resp, err := client.R().
 		SetHeader("Cookie", util.FormatCookieString(cookies)).
-		Get("https://www.bing.com/search?q=Bing+AI&showconv=1")
+		Get(o.endpoints.Bing + "/search?q=Bing+AI&showconv=1")
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,36 @@
+package sydney
+
+import (
+	"strings"
+
+	"github.com/cphovo/ollm/util"
+)
+
+// Endpoints are the upstream urls a Sydney instance talks to. Empty fields
+// use the official Bing endpoints, so mirrors, reverse proxies or a mock
+// upstream only need to set what differs.
+type Endpoints struct {
+	// https://www.bing.com, used for image and music creation, image upload, CAPTCHA and the Referer
+	Bing string `json:"bing"`
+	// https://sydney.bing.com, used for file upload
+	Sydney string `json:"sydney"`
+	// wss://sydney.bing.com/sydney/ChatHub
+	ChatHub string `json:"chatHub"`
+	// https://edgeservices.bing.com/edgesvc/turing/conversation/create
+	CreateConversation string `json:"createConversation"`
+	// https://th.bing.com, where generated music is served
+	Thumbnail string `json:"thumbnail"`
+}
+
+func (e Endpoints) withDefaults() Endpoints {
+	trim := func(v, def string) string {
+		return strings.TrimSuffix(util.Ternary(v == "", def, v), "/")
+	}
+	return Endpoints{
+		Bing:               trim(e.Bing, "https://www.bing.com"),
+		Sydney:             trim(e.Sydney, "https://sydney.bing.com"),
+		ChatHub:            trim(e.ChatHub, "wss://sydney.bing.com/sydney/ChatHub"),
+		CreateConversation: trim(e.CreateConversation, "https://edgeservices.bing.com/edgesvc/turing/conversation/create"),
+		Thumbnail:          trim(e.Thumbnail, "https://th.bing.com"),
+	}
+}
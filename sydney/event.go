@@ -0,0 +1,51 @@
+package sydney
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// StreamEvent is an intermediate event of a Bing answer, e.g. a search query
+// or the progress of the code interpreter. Only the field of its type is set.
+type StreamEvent struct {
+	Type string `json:"type"`
+	// search_query, loading, executing_task, execution_output, openapi_call and resolving_captcha
+	Text string `json:"text,omitempty"`
+	// generated_code
+	Code string `json:"code,omitempty"`
+	// suggested_responses
+	Suggestions []string         `json:"suggestions,omitempty"`
+	Image       *GenerativeImage `json:"image,omitempty"`
+	Music       *GenerativeMusic `json:"music,omitempty"`
+	// generated_file of the code interpreter
+	File *GeneratedFile `json:"file,omitempty"`
+}
+
+// NewStreamEvent converts a message to an event, it returns nil for the
+// answer text, errors and other messages that are not events.
+func NewStreamEvent(message Message) *StreamEvent {
+	event := &StreamEvent{Type: message.Type}
+	var err error
+	switch message.Type {
+	case MessageTypeSearchQuery, MessageTypeLoading, MessageTypeExecutingTask,
+		MessageTypeExecutionOutput, MessageTypeOpenAPICall, MessageTypeResolvingCaptcha:
+		event.Text = message.Text
+	case MessageTypeGeneratedCode:
+		event.Code = message.Text
+	case MessageTypeSuggestedResponses:
+		err = json.Unmarshal([]byte(message.Text), &event.Suggestions)
+	case MessageTypeGenerativeImage:
+		err = json.Unmarshal([]byte(message.Text), &event.Image)
+	case MessageTypeGenerativeMusic:
+		err = json.Unmarshal([]byte(message.Text), &event.Music)
+	case MessageTypeGeneratedFile:
+		err = json.Unmarshal([]byte(message.Text), &event.File)
+	default:
+		return nil
+	}
+	if err != nil {
+		slog.Warn("Cannot parse event", "type", message.Type, "err", err)
+		return nil
+	}
+	return event
+}
@@ -0,0 +1,21 @@
+package sydney
+
+import "testing"
+
+func TestNewStreamEvent(t *testing.T) {
+	event := NewStreamEvent(Message{Type: MessageTypeSearchQuery, Text: "weather today"})
+	if event == nil || event.Type != MessageTypeSearchQuery || event.Text != "weather today" {
+		t.Errorf("unexpected search query event: %+v", event)
+	}
+
+	event = NewStreamEvent(Message{Type: MessageTypeSuggestedResponses, Text: `["a","b"]`})
+	if event == nil || len(event.Suggestions) != 2 || event.Suggestions[1] != "b" {
+		t.Errorf("unexpected suggested responses event: %+v", event)
+	}
+
+	for _, typ := range []string{MessageTypeMessageText, MessageTypeError, MessageTypeConversationID, MessageTypeSearchResult} {
+		if event := NewStreamEvent(Message{Type: typ, Text: "x"}); event != nil {
+			t.Errorf("%s should not be an event", typ)
+		}
+	}
+}
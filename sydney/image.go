@@ -18,7 +18,7 @@ func (o *Sydney) GenerateImage(generativeImage GenerativeImage) (GenerateImageRe
 	if err != nil {
 		return empty, err
 	}
-	client.SetCommonHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&wlexpsignin=1").
+	client.SetCommonHeader("Referer", o.endpoints.Bing+"/search?q=Bing+AI&showconv=1&wlexpsignin=1").
 		SetCommonHeader("Cookie", util.FormatCookieString(o.cookies))
 	resp, err := client.R().Get(generativeImage.URL)
 	if err != nil {
@@ -31,7 +31,7 @@ func (o *Sydney) GenerateImage(generativeImage GenerativeImage) (GenerateImageRe
 	}
 	resultID := arr[1]
 	re := regexp.MustCompile(`<img class="mimg".*?src="(.*?)"`)
-	u := "https://www.bing.com/images/create/async/results/" + resultID +
+	u := o.endpoints.Bing + "/images/create/async/results/" + resultID +
 		"?q=" + url.QueryEscape(generativeImage.Text) + "&partner=sydney&showselective=1&IID=images.as"
 	slog.Info("Result URL", "v", u)
 	for i := 0; i < 15; i++ {
@@ -0,0 +1,134 @@
+package sydney
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cphovo/ollm/util"
+)
+
+// Location is where Bing believes the user is, it decides the search results
+type Location struct {
+	// e.g. "Los Angeles, California"
+	Name    string `json:"name"`
+	City    string `json:"city"`
+	Admin1  string `json:"admin1"`
+	Country string `json:"country"`
+	// Country code, e.g. "US"
+	Region    string  `json:"region"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	PostCode  string  `json:"postCode"`
+	// IANA name, e.g. "America/Los_Angeles", used for the UTC offset when the tz database is available
+	Timezone  string `json:"timezone"`
+	UtcOffset int    `json:"utcOffset"`
+	Dma       int    `json:"dma"`
+	// x-forwarded-for is this prefix plus a random last byte, e.g. "1.0.0."
+	IPPrefix string `json:"ipPrefix"`
+}
+
+// DefaultLocation is used when no location is given
+const DefaultLocation = "los-angeles"
+
+// LocationPresets are the built-in locations, more can be added per instance
+var LocationPresets = map[string]Location{
+	"los-angeles": {
+		Name: "Los Angeles, California", City: "Los Angeles", Admin1: "California",
+		Country: "United States", Region: "US", Latitude: 33.97570037841797, Longitude: -118.25640106201172,
+		PostCode: "90060", Timezone: "America/Los_Angeles", UtcOffset: -8, Dma: 803, IPPrefix: "1.0.0.",
+	},
+	"new-york": {
+		Name: "New York, New York", City: "New York", Admin1: "New York",
+		Country: "United States", Region: "US", Latitude: 40.7128, Longitude: -74.006,
+		PostCode: "10001", Timezone: "America/New_York", UtcOffset: -5, Dma: 501, IPPrefix: "1.0.0.",
+	},
+	"london": {
+		Name: "London, England", City: "London", Admin1: "England",
+		Country: "United Kingdom", Region: "GB", Latitude: 51.5074, Longitude: -0.1278,
+		PostCode: "EC1A", Timezone: "Europe/London", UtcOffset: 0, IPPrefix: "81.2.69.",
+	},
+	"paris": {
+		Name: "Paris, Île-de-France", City: "Paris", Admin1: "Île-de-France",
+		Country: "France", Region: "FR", Latitude: 48.8566, Longitude: 2.3522,
+		PostCode: "75001", Timezone: "Europe/Paris", UtcOffset: 1, IPPrefix: "90.0.0.",
+	},
+	"berlin": {
+		Name: "Berlin, Berlin", City: "Berlin", Admin1: "Berlin",
+		Country: "Germany", Region: "DE", Latitude: 52.52, Longitude: 13.405,
+		PostCode: "10115", Timezone: "Europe/Berlin", UtcOffset: 1, IPPrefix: "85.214.0.",
+	},
+	"tokyo": {
+		Name: "Tokyo, Tokyo", City: "Tokyo", Admin1: "Tokyo",
+		Country: "Japan", Region: "JP", Latitude: 35.6762, Longitude: 139.6503,
+		PostCode: "100-0001", Timezone: "Asia/Tokyo", UtcOffset: 9, IPPrefix: "133.242.0.",
+	},
+	"beijing": {
+		Name: "Beijing, Beijing", City: "Beijing", Admin1: "Beijing",
+		Country: "China", Region: "CN", Latitude: 39.9042, Longitude: 116.4074,
+		PostCode: "100000", Timezone: "Asia/Shanghai", UtcOffset: 8, IPPrefix: "114.114.114.",
+	},
+	"shanghai": {
+		Name: "Shanghai, Shanghai", City: "Shanghai", Admin1: "Shanghai",
+		Country: "China", Region: "CN", Latitude: 31.2304, Longitude: 121.4737,
+		PostCode: "200000", Timezone: "Asia/Shanghai", UtcOffset: 8, IPPrefix: "101.226.0.",
+	},
+	"hong-kong": {
+		Name: "Hong Kong", City: "Hong Kong", Admin1: "Hong Kong",
+		Country: "Hong Kong SAR", Region: "HK", Latitude: 22.3193, Longitude: 114.1694,
+		Timezone: "Asia/Hong_Kong", UtcOffset: 8, IPPrefix: "203.198.0.",
+	},
+	"singapore": {
+		Name: "Singapore", City: "Singapore", Admin1: "Singapore",
+		Country: "Singapore", Region: "SG", Latitude: 1.3521, Longitude: 103.8198,
+		PostCode: "018956", Timezone: "Asia/Singapore", UtcOffset: 8, IPPrefix: "203.116.0.",
+	},
+}
+
+// utcOffset 优先按时区计算，这样夏令时也是对的
+func (l Location) utcOffset() int {
+	if l.Timezone != "" {
+		if tz, err := time.LoadLocation(l.Timezone); err == nil {
+			_, offset := time.Now().In(tz).Zone()
+			return offset / 3600
+		}
+	}
+	return l.UtcOffset
+}
+
+func (l Location) hint() LocationHint {
+	return LocationHint{
+		SourceType: 1,
+		RegionType: 2,
+		Center: LatLng{
+			Latitude:  l.Latitude,
+			Longitude: l.Longitude,
+		},
+		Radius:                   24902,
+		Name:                     l.Name,
+		Accuracy:                 24902,
+		FDConfidence:             0.5,
+		CountryName:              l.Country,
+		CountryConfidence:        8,
+		Admin1Name:               l.Admin1,
+		PopulatedPlaceName:       l.City,
+		PopulatedPlaceConfidence: 5,
+		PostCodeName:             l.PostCode,
+		UtcOffset:                l.utcOffset(),
+		Dma:                      l.Dma,
+	}
+}
+
+func (l Location) forwardedIP() string {
+	return util.Ternary(l.IPPrefix == "", "1.0.0.", l.IPPrefix) + strconv.Itoa(util.RandIntInclusive(1, 255))
+}
+
+// acceptLanguage builds the accept-language header of a locale, e.g. "zh-CN,zh;q=0.9,en;q=0.8"
+func acceptLanguage(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	if language == "en" {
+		return fmt.Sprintf("%s,en;q=0.9", locale)
+	}
+	return fmt.Sprintf("%s,%s;q=0.9,en;q=0.8", locale, language)
+}
@@ -37,9 +37,9 @@ func (o *Sydney) GenerateMusic(generativeMusic GenerativeMusic) (GenerateMusicRe
 	if err != nil {
 		return empty, err
 	}
-	client.SetCommonHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&wlexpsignin=1").
+	client.SetCommonHeader("Referer", o.endpoints.Bing+"/search?q=Bing+AI&showconv=1&wlexpsignin=1").
 		SetCommonHeader("Cookie", util.FormatCookieString(o.cookies))
-	u0 := "https://www.bing.com/videos/music?vdpp=suno&kseed=8000&SFX=3&q=&" +
+	u0 := o.endpoints.Bing + "/videos/music?vdpp=suno&kseed=8000&SFX=3&q=&" +
 		"iframeid=" + generativeMusic.IFrameID + "&requestid=" + generativeMusic.RequestID
 	resp, err := client.R().Get(u0)
 	if err != nil {
@@ -52,7 +52,7 @@ func (o *Sydney) GenerateMusic(generativeMusic GenerativeMusic) (GenerateMusicRe
 	if len(arr) < 2 {
 		return empty, errors.New("cannot find music creation skey")
 	}
-	u1 := "https://www.bing.com/videos/api/custom/music?skey=" + arr[1] +
+	u1 := o.endpoints.Bing + "/videos/api/custom/music?skey=" + arr[1] +
 		"&safesearch=Moderate&vdpp=suno&" +
 		"requestid=" + generativeMusic.RequestID + "&" +
 		"ig=" + hex.NewUpperHex(32) + "&iid=vsn&sfx=1"
@@ -83,9 +83,9 @@ func (o *Sydney) GenerateMusic(generativeMusic GenerativeMusic) (GenerateMusicRe
 		}
 		return GenerateMusicResult{
 			GenerativeMusic: generativeMusic,
-			CoverImgURL:     "https://th.bing.com/th?&id=" + realResp.ImageKey,
-			AudioURL:        "https://th.bing.com/th?&id=" + realResp.AudioKey,
-			VideoURL:        "https://th.bing.com/th?&id=" + realResp.VideoKey,
+			CoverImgURL:     o.endpoints.Thumbnail + "/th?&id=" + realResp.ImageKey,
+			AudioURL:        o.endpoints.Thumbnail + "/th?&id=" + realResp.AudioKey,
+			VideoURL:        o.endpoints.Thumbnail + "/th?&id=" + realResp.VideoKey,
 			MusicDuration:   time.Duration(realResp.Duration * float64(time.Second)),
 			MusicalStyle:    realResp.MusicalStyle,
 			Title:           realResp.GptPrompt,
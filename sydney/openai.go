@@ -7,12 +7,13 @@ import (
 )
 
 var (
-	ErrMissingPrompt     = errors.New("user prompt is missing (last message is not sent by user)")
-	FinishReasonStop     = "stop"
-	FinishReasonLength   = "length"
-	MessageRoleUser      = "user"
-	MessageRoleAssistant = "assistant"
-	MessageRoleSystem    = "system"
+	ErrMissingPrompt          = errors.New("user prompt is missing (last message is not sent by user)")
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonContentFilter = "content_filter"
+	MessageRoleUser           = "user"
+	MessageRoleAssistant      = "assistant"
+	MessageRoleSystem         = "system"
 )
 
 func ParseOpenAIMessages(messages []OpenAIMessage) (OpenAIMessagesParseResult, error) {
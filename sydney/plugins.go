@@ -1,11 +1,37 @@
 package sydney
 
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cphovo/ollm/util"
+	"github.com/samber/lo"
+)
+
 type Plugin struct {
-	Name        string
-	OptionsSets []string
+	Name        string   `json:"name"`
+	OptionsSets []string `json:"optionsSets"`
 	ArgumentPlugin
+	// Model id of the plugin in the OpenAI API, empty if not exposed
+	Model string `json:"model"`
 }
 
+// Persona is a Bing GPT, e.g. the Designer
+type Persona struct {
+	Name string `json:"name"`
+	// gptId of the chat request
+	GptID       string   `json:"gptId"`
+	OptionsSets []string `json:"optionsSets"`
+	// Model id of the persona in the OpenAI API, empty if not exposed
+	Model string `json:"model"`
+}
+
+// DefaultPersona is the gptId used without a persona
+const DefaultPersona = "copilot"
+
 var PluginList = []Plugin{
 	{
 		Name:        "Suno",
@@ -16,3 +42,78 @@ var PluginList = []Plugin{
 		},
 	},
 }
+
+var PersonaList = []Persona{
+	{
+		Name:        "Designer",
+		GptID:       "designer",
+		OptionsSets: []string{"ai_persona_designer_gpt"},
+	},
+}
+
+// registry 在启动时加载，之后只读
+var registryMutex sync.RWMutex
+
+// Registry is the format of bing_plugins.json
+type Registry struct {
+	Plugins  []Plugin  `json:"plugins"`
+	Personas []Persona `json:"personas"`
+}
+
+// ReadRegistryFile reads bing_plugins.json, it returns an empty registry when the file does not exist
+func ReadRegistryFile() (Registry, error) {
+	var registry Registry
+	v, err := os.ReadFile(util.WithPath("bing_plugins.json"))
+	if err != nil {
+		return registry, nil
+	}
+	if err := json.Unmarshal(v, &registry); err != nil {
+		return registry, fmt.Errorf("failed to json.Unmarshal content of bing plugins file: %w", err)
+	}
+	for _, plugin := range registry.Plugins {
+		if plugin.Name == "" || plugin.Id == "" {
+			return registry, fmt.Errorf("plugin %q needs a name and an id", plugin.Name)
+		}
+	}
+	for _, persona := range registry.Personas {
+		if persona.Name == "" || persona.GptID == "" {
+			return registry, fmt.Errorf("persona %q needs a name and a gptId", persona.Name)
+		}
+	}
+	return registry, nil
+}
+
+// Register adds the plugins and personas of the registry, they replace the
+// built-in ones of the same name.
+func Register(registry Registry) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, plugin := range registry.Plugins {
+		if _, i, ok := lo.FindIndexOf(PluginList, func(p Plugin) bool { return strings.EqualFold(p.Name, plugin.Name) }); ok {
+			PluginList[i] = plugin
+		} else {
+			PluginList = append(PluginList, plugin)
+		}
+	}
+	for _, persona := range registry.Personas {
+		if _, i, ok := lo.FindIndexOf(PersonaList, func(p Persona) bool { return strings.EqualFold(p.Name, persona.Name) }); ok {
+			PersonaList[i] = persona
+		} else {
+			PersonaList = append(PersonaList, persona)
+		}
+	}
+}
+
+// FindPlugin looks up a plugin by name, case-insensitively
+func FindPlugin(name string) (Plugin, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return util.FindFirst(PluginList, func(p Plugin) bool { return strings.EqualFold(p.Name, name) })
+}
+
+// FindPersona looks up a persona by name, case-insensitively
+func FindPersona(name string) (Persona, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return util.FindFirst(PersonaList, func(p Persona) bool { return strings.EqualFold(p.Name, name) })
+}
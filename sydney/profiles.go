@@ -0,0 +1,143 @@
+package sydney
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cphovo/ollm/util"
+)
+
+// OptionSetProfile changes the option sets NewSydney builds from the
+// conversation style, persona and flags. Plugin option sets are added after it.
+type OptionSetProfile struct {
+	// Profile applied before this one
+	Extends string `json:"extends,omitempty"`
+	// Replaces all the option sets, like the old debug_options_sets.json
+	Replace []string `json:"replace,omitempty"`
+	Add     []string `json:"add,omitempty"`
+	Remove  []string `json:"remove,omitempty"`
+}
+
+// OptionSetProfiles is the format of bing_option_sets.json
+type OptionSetProfiles struct {
+	// Profile used when neither the request nor the model selects one, empty is none
+	Default string `json:"default"`
+	// Model id -> profile
+	Models   map[string]string           `json:"models,omitempty"`
+	Profiles map[string]OptionSetProfile `json:"profiles"`
+}
+
+// extends 的最大层数，超过视为循环引用
+const maxProfileDepth = 8
+
+var currentProfiles atomic.Pointer[OptionSetProfiles]
+
+// Apply returns the option sets after the named profile and the profiles it extends
+func (p OptionSetProfiles) Apply(name string, optionsSet []string) ([]string, error) {
+	return p.apply(name, slices.Clone(optionsSet), 0)
+}
+
+func (p OptionSetProfiles) apply(name string, optionsSet []string, depth int) ([]string, error) {
+	if depth > maxProfileDepth {
+		return nil, fmt.Errorf("option set profile %s extends itself", name)
+	}
+	profile, ok := p.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("option set profile not found: %s", name)
+	}
+	var err error
+	if profile.Extends != "" {
+		optionsSet, err = p.apply(profile.Extends, optionsSet, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if profile.Replace != nil {
+		optionsSet = slices.Clone(profile.Replace)
+	}
+	optionsSet = slices.DeleteFunc(optionsSet, func(s string) bool {
+		return slices.Contains(profile.Remove, s)
+	})
+	for _, s := range profile.Add {
+		if !slices.Contains(optionsSet, s) {
+			optionsSet = append(optionsSet, s)
+		}
+	}
+	return optionsSet, nil
+}
+
+// validate checks that every profile and reference resolves
+func (p OptionSetProfiles) validate() error {
+	for name := range p.Profiles {
+		if _, err := p.Apply(name, nil); err != nil {
+			return err
+		}
+	}
+	if _, ok := p.Profiles[p.Default]; p.Default != "" && !ok {
+		return fmt.Errorf("default option set profile not found: %s", p.Default)
+	}
+	for model, name := range p.Models {
+		if _, ok := p.Profiles[name]; !ok {
+			return fmt.Errorf("option set profile of model %s not found: %s", model, name)
+		}
+	}
+	return nil
+}
+
+// SetOptionSetProfiles validates and installs the profiles used by NewSydney,
+// the running conversations keep their option sets.
+func SetOptionSetProfiles(profiles OptionSetProfiles) error {
+	if err := profiles.validate(); err != nil {
+		return err
+	}
+	currentProfiles.Store(&profiles)
+	return nil
+}
+
+// CurrentOptionSetProfiles returns the profiles in use
+func CurrentOptionSetProfiles() OptionSetProfiles {
+	if p := currentProfiles.Load(); p != nil {
+		return *p
+	}
+	return OptionSetProfiles{}
+}
+
+// HasOptionSetProfile reports whether a profile of the name is in use
+func HasOptionSetProfile(name string) bool {
+	_, ok := CurrentOptionSetProfiles().Profiles[name]
+	return ok
+}
+
+// ReadOptionSetProfilesFile reads bing_option_sets.json. Without it, a
+// debug_options_sets.json array becomes a default profile replacing all the option sets.
+func ReadOptionSetProfilesFile() (OptionSetProfiles, error) {
+	var profiles OptionSetProfiles
+	v, err := os.ReadFile(util.WithPath("bing_option_sets.json"))
+	if err == nil {
+		if err := json.Unmarshal(v, &profiles); err != nil {
+			return profiles, fmt.Errorf("failed to json.Unmarshal content of bing option sets file: %w", err)
+		}
+		return profiles, profiles.validate()
+	}
+
+	v, err = os.ReadFile(util.WithPath("debug_options_sets.json"))
+	if err != nil || strings.TrimSpace(string(v)) == "" {
+		return profiles, nil
+	}
+	var debugOptionSets []string
+	if err := json.Unmarshal(v, &debugOptionSets); err != nil {
+		return profiles, fmt.Errorf("failed to json.Unmarshal content of debug options sets file: %w", err)
+	}
+	if len(debugOptionSets) == 0 {
+		return profiles, nil
+	}
+	slog.Warn("debug_options_sets.json is deprecated, use a profile of bing_option_sets.json", "v", debugOptionSets)
+	profiles.Default = "debug"
+	profiles.Profiles = map[string]OptionSetProfile{"debug": {Replace: debugOptionSets}}
+	return profiles, nil
+}
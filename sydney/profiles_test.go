@@ -0,0 +1,51 @@
+package sydney
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptionSetProfiles(t *testing.T) {
+	profiles := OptionSetProfiles{
+		Default: "base",
+		Models:  map[string]string{"Precise": "lite"},
+		Profiles: map[string]OptionSetProfile{
+			"base":  {Add: []string{"c"}, Remove: []string{"a"}},
+			"lite":  {Extends: "base", Add: []string{"d", "c"}},
+			"fixed": {Replace: []string{"x"}, Add: []string{"y"}},
+		},
+	}
+	if err := SetOptionSetProfiles(profiles); err != nil {
+		t.Fatal(err)
+	}
+	defer currentProfiles.Store(nil)
+
+	for name, expected := range map[string][]string{
+		"base":  {"b", "c"},
+		"lite":  {"b", "c", "d"},
+		"fixed": {"x", "y"},
+	} {
+		got, err := CurrentOptionSetProfiles().Apply(name, []string{"a", "b"})
+		if err != nil || !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %v, got %v, %v", name, expected, got, err)
+		}
+	}
+	if !HasOptionSetProfile("lite") || HasOptionSetProfile("unknown") {
+		t.Error("unexpected HasOptionSetProfile result")
+	}
+
+	for _, invalid := range []OptionSetProfiles{
+		{Profiles: map[string]OptionSetProfile{"a": {Extends: "b"}, "b": {Extends: "a"}}},
+		{Profiles: map[string]OptionSetProfile{"a": {Extends: "missing"}}},
+		{Default: "missing"},
+		{Models: map[string]string{"Creative": "missing"}},
+	} {
+		if err := SetOptionSetProfiles(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+	// 无效的配置不会替换当前的配置
+	if !HasOptionSetProfile("lite") {
+		t.Error("invalid profiles replaced the current ones")
+	}
+}
@@ -6,19 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/cphovo/ollm/metrics"
+	"github.com/cphovo/ollm/tracing"
 	"github.com/cphovo/ollm/util"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/google/uuid"
 	"github.com/samber/lo"
 	"github.com/tidwall/gjson"
-	"nhooyr.io/websocket"
 )
 
 func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
@@ -33,6 +34,10 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 			slog.Info("AskStream is closing out message channel")
 			close(out)
 		}()
+		out <- Message{
+			Type: MessageTypeConversationID,
+			Text: conversation.ConversationId,
+		}
 		wrote := 0
 		sendSuggestedResponses := func(message gjson.Result) {
 			if message.Get("suggestedResponses").Exists() {
@@ -48,11 +53,14 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 		}
 		var sourceAttributes []SourceAttribute
 		tmpLastDocLoadingMessage := "" // for removing duplicate doc loading messages
+		codeOutput := ""               // for removing duplicate code interpreter outputs
+		sentFiles := map[string]bool{} // for removing duplicate code interpreter files
 		for msg := range ch {
 			if msg.Error != nil {
 				slog.Error("Ask stream message", "error", msg.Error)
 				if strings.Contains(msg.Error.Error(), "CAPTCHA") {
 					if options.disableCaptchaBypass {
+						metrics.CaptchaEvents.WithLabelValues("unresolved").Inc()
 						err0 := errors.New("infinite CAPTCHA detected; " +
 							"please resolve it manually on Bing's website or mobile client")
 						out <- Message{
@@ -62,19 +70,19 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 						}
 						return
 					}
-					slog.Info("Start to resolve the captcha", "server", o.bypassServer)
+					metrics.CaptchaEvents.WithLabelValues("detected").Inc()
+					slog.Info("Start to resolve the captcha", "solver", o.captchaSolver.Name())
 					out <- Message{
 						Type: MessageTypeResolvingCaptcha,
 						Text: "Please wait patiently while we are resolving the CAPTCHA...",
 					}
-					if o.bypassServer == "" {
-						err = o.ResolveCaptcha(options.StopCtx)
-					} else {
-						err = o.BypassCaptcha(options.StopCtx, conversation.ConversationId,
-							options.messageID)
-					}
+					captchaCtx, span := tracing.Start(options.StopCtx, "bing.captcha",
+						attribute.String("bing.captcha.solver", o.captchaSolver.Name()))
+					err = o.solveCaptcha(captchaCtx, conversation.ConversationId, options.messageID)
+					tracing.End(span, err)
 					if err != nil {
 						if !errors.Is(err, context.Canceled) {
+							metrics.CaptchaEvents.WithLabelValues("failed").Inc()
 							err = fmt.Errorf("cannot resolve CAPTCHA automatically; "+
 								"please resolve it manually on Bing's website or mobile client: %w", err)
 							out <- Message{
@@ -85,6 +93,7 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 						}
 						return
 					}
+					metrics.CaptchaEvents.WithLabelValues("solved").Inc()
 					newOptions := options
 					newOptions.disableCaptchaBypass = true
 					newOptions.messageID = ""
@@ -102,6 +111,9 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 					}
 					return
 				} else {
+					if strings.Contains(msg.Error.Error(), "Throttled") {
+						metrics.Throttles.WithLabelValues("bing").Inc()
+					}
 					out <- Message{
 						Type:  MessageTypeError,
 						Text:  msg.Error.Error(),
@@ -117,6 +129,32 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 				messageText := message.Get("text").String()
 				messageHiddenText := message.Get("hiddenText").String()
 				contentOrigin := message.Get("contentOrigin").String()
+				// 代码解释器生成的图表和文件，以图片卡片或 Markdown 链接的形式出现
+				if contentOrigin == "CodeInterpreter" {
+					for _, file := range codeInterpreterFiles(message, o.endpoints.Bing) {
+						if sentFiles[file.URL] {
+							continue
+						}
+						sentFiles[file.URL] = true
+						v, _ := json.Marshal(&file)
+						out <- Message{
+							Type: MessageTypeGeneratedFile,
+							Text: string(v),
+						}
+					}
+				}
+				// 代码的运行结果，和回答一样会多次更新，每次发送完整的结果
+				if contentOrigin == "CodeInterpreter" && msgType.String() != "" &&
+					msgType.String() != "Progress" && msgType.String() != "GeneratedCode" {
+					if text := messageText; text != "" && text != codeOutput {
+						codeOutput = text
+						out <- Message{
+							Type: MessageTypeExecutionOutput,
+							Text: text,
+						}
+					}
+					continue
+				}
 				switch msgType.String() {
 				case "InternalSearchQuery":
 					out <- Message{
@@ -182,14 +220,19 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 					case "IMAGE":
 						generativeImage := GenerativeImage{
 							Text: messageText,
-							URL: "https://www.bing.com/images/create?" +
+							URL: o.endpoints.Bing + "/images/create?" +
 								"partner=sydney&re=1&showselective=1&sude=1&kseed=7500&SFX=2&gptexp=unknown" +
 								"&q=" + url.QueryEscape(messageText) + "&iframeid=" +
 								message.Get("messageId").String(),
 						}
 						v, err := json.Marshal(&generativeImage)
 						if err != nil {
-							util.GracefulPanic(err)
+							out <- Message{
+								Type:  MessageTypeError,
+								Text:  err.Error(),
+								Error: err,
+							}
+							return
 						}
 						out <- Message{
 							Type: MessageTypeGenerativeImage,
@@ -203,7 +246,12 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 						}
 						v, err := json.Marshal(&generativeMusic)
 						if err != nil {
-							util.GracefulPanic(err)
+							out <- Message{
+								Type:  MessageTypeError,
+								Text:  err.Error(),
+								Error: err,
+							}
+							return
 						}
 						out <- Message{
 							Type: MessageTypeGenerativeMusic,
@@ -238,6 +286,7 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 							"triggered-by", options.Prompt, "response", message.Raw)
 					}
 				case "GeneratedCode":
+					codeOutput = ""
 					out <- Message{
 						Type: MessageTypeGeneratedCode,
 						Text: messageText,
@@ -325,7 +374,10 @@ func (o *Sydney) AskStream(options AskStreamOptions) (<-chan Message, error) {
 }
 func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationResponse, <-chan RawMessage, error) {
 	slog.Info("AskStreamRaw called, creating conversation...")
+	_, span := tracing.Start(options.StopCtx, "bing.create_conversation")
 	conversation, err := o.createConversation()
+	span.SetAttributes(attribute.String("bing.conversation_id", conversation.ConversationId))
+	tracing.End(span, err)
 	if err != nil {
 		return CreateConversationResponse{}, nil, err
 	}
@@ -346,7 +398,9 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 	var uploadFileResult UploadFileResult
 	if options.UploadFilePath != "" {
 		slog.Info("Invoke file upload", "path", options.UploadFilePath)
+		_, span := tracing.Start(options.StopCtx, "bing.upload_file")
 		uploadFileResult, err = o.uploadFile(options.UploadFilePath, conversation)
+		tracing.End(span, err)
 		if err != nil {
 			return CreateConversationResponse{}, nil, err
 		}
@@ -370,71 +424,53 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 			slog.Info("AskStreamRaw is closing raw message channel")
 			close(msgChan)
 		}(msgChan)
-		client, _, err := util.MakeHTTPClient(o.proxy, 0)
-		if err != nil {
+		// 先是建立 websocket 连接的阶段，连接成功后切换到生成阶段，goroutine 退出时结束当前阶段
+		_, span := tracing.Start(options.StopCtx, "bing.websocket_dial")
+		var spanErr error
+		defer func() { tracing.End(span, spanErr) }()
+		sendError := func(err error) {
+			spanErr = err
 			msgChan <- RawMessage{
 				Error: err,
 			}
-			return
 		}
 		messageID := options.messageID
 		if messageID == "" {
 			msgID, err := uuid.NewUUID()
 			if err != nil {
-				msgChan <- RawMessage{
-					Error: err,
-				}
+				sendError(err)
 				return
 			}
 			messageID = msgID.String()
 		}
-		httpHeaders := http.Header{}
-		for k, v := range o.headers() {
-			httpHeaders.Set(k, v)
-		}
-		ctx, cancel := util.CreateTimeoutContext(10 * time.Second)
-		defer cancel()
-		connRaw, resp, err := websocket.Dial(ctx,
-			o.wssURL+util.Ternary(conversation.SecAccessToken != "", "?sec_access_token="+
-				url.QueryEscape(conversation.SecAccessToken), ""),
-			&websocket.DialOptions{
-				HTTPClient: client,
-				HTTPHeader: httpHeaders,
-			})
+		conn, err := o.connect(conversation)
 		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
+			sendError(err)
 			return
 		}
-		if resp.StatusCode != 101 {
-			msgChan <- RawMessage{
-				Error: errors.New("cannot establish a websocket connection"),
-			}
-			return
-		}
-		defer connRaw.CloseNow()
+		defer conn.CloseNow()
+		// 取消时关闭连接，让阻塞中的读取立即返回
+		stopClose := context.AfterFunc(options.StopCtx, func() { conn.CloseNow() })
+		defer stopClose()
 		select {
 		case <-options.StopCtx.Done():
 			slog.Info("Exit askStream because of received signal from stopCtx")
 			return
 		default:
 		}
-		connRaw.SetReadLimit(-1)
-		conn := &Conn{Conn: connRaw, debug: o.debug}
+		tracing.End(span, nil)
+		_, span = tracing.Start(options.StopCtx, "bing.generate",
+			attribute.String("bing.conversation_id", conversation.ConversationId),
+			attribute.String("bing.tone", o.conversationStyle))
 		err = conn.WriteWithTimeout([]byte(`{"protocol": "json", "version": 1}`))
 		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
+			sendError(err)
 			return
 		}
 		conn.ReadWithTimeout()
 		err = conn.WriteWithTimeout([]byte(`{"type": 6}`))
 		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
+			sendError(err)
 			return
 		}
 		chatMessage := ChatMessage{
@@ -452,8 +488,8 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 					IsStartOfSession:    true,
 					Message: ArgumentMessage{
 						Locale: o.locale,
-						Market: o.locale,
-						Region: "US",
+						Market: o.market,
+						Region: o.region,
 						Location: fmt.Sprintf("lat:%.6f;long:%.6f;re=1000m;",
 							o.locationHint.Center.Latitude,
 							o.locationHint.Center.Longitude),
@@ -490,16 +526,12 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 		}
 		chatMessageV, err := json.Marshal(&chatMessage)
 		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
+			sendError(err)
 			return
 		}
 		err = conn.WriteWithTimeout(chatMessageV)
 		if err != nil {
-			msgChan <- RawMessage{
-				Error: err,
-			}
+			sendError(err)
 			return
 		}
 		for {
@@ -511,17 +543,17 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 			}
 			messages, err := conn.ReadWithTimeout()
 			if err != nil {
-				msgChan <- RawMessage{
-					Error: err,
+				if options.StopCtx.Err() != nil {
+					slog.Info("Exit askStream because of received signal from stopCtx")
+					return
 				}
+				sendError(err)
 				return
 			}
 			if time.Now().Unix()%6 == 0 {
 				err = conn.WriteWithTimeout([]byte(`{"type": 6}`))
 				if err != nil {
-					msgChan <- RawMessage{
-						Error: err,
-					}
+					sendError(err)
 					return
 				}
 			}
@@ -530,18 +562,14 @@ func (o *Sydney) AskStreamRaw(options AskStreamOptions) (CreateConversationRespo
 					continue
 				}
 				if !gjson.Valid(msg) {
-					msgChan <- RawMessage{
-						Error: errors.New("malformed json"),
-					}
+					sendError(errors.New("malformed json"))
 					return
 				}
 				result := gjson.Parse(msg)
 				if result.Get("type").Int() == 2 && result.Get("item.result.value").String() != "Success" {
-					msgChan <- RawMessage{
-						Error: errors.New("bing explicit error: value: " +
-							result.Get("item.result.value").String() + "; message: " +
-							result.Get("item.result.message").String()),
-					}
+					sendError(errors.New("bing explicit error: value: " +
+						result.Get("item.result.value").String() + "; message: " +
+						result.Get("item.result.message").String()))
 					return
 				}
 				msgChan <- RawMessage{
@@ -0,0 +1,138 @@
+package sydney
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cphovo/ollm/replay"
+)
+
+// chdirTemp runs the test in a temporary directory, so the cookies.json
+// written by UpdateModifiedCookies does not end up in the package
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func askReplay(t *testing.T, fixture string) []Message {
+	t.Helper()
+	fixture, err := filepath.Abs(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chdirTemp(t)
+	session, err := replay.Start(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := session.Save(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	sydney, err := NewSydney(Options{
+		Cookies: map[string]string{"_U": "test"},
+		Replay:  session,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageCh, err := sydney.AskStream(AskStreamOptions{
+		StopCtx: context.Background(),
+		Prompt:  "When was Go 1.22 released?",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []Message
+	for message := range messageCh {
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestAskStreamReplay(t *testing.T) {
+	messages := askReplay(t, "testdata/chat.json")
+
+	var types, texts []string
+	for _, message := range messages {
+		types = append(types, message.Type)
+		if message.Type == MessageTypeMessageText {
+			texts = append(texts, message.Text)
+		}
+	}
+	wantTypes := []string{
+		MessageTypeConversationID,
+		MessageTypeSearchQuery,
+		MessageTypeSearchResult,
+		MessageTypeMessageText,
+		MessageTypeMessageText,
+		MessageTypeSuggestedResponses,
+	}
+	if len(types) != len(wantTypes) {
+		t.Fatalf("types = %v, want %v", types, wantTypes)
+	}
+	for i := range wantTypes {
+		if types[i] != wantTypes[i] {
+			t.Fatalf("types = %v, want %v", types, wantTypes)
+		}
+	}
+
+	if messages[0].Text != "51D|BingProdUnAuthenticatedUsers|CHAT" {
+		t.Errorf("conversation id = %q", messages[0].Text)
+	}
+	if messages[1].Text != "golang release" {
+		t.Errorf("search query = %q", messages[1].Text)
+	}
+
+	var sources []SourceAttribute
+	if err := json.Unmarshal([]byte(messages[2].Text), &sources); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Index != 1 || sources[0].Link != "https://go.dev/doc/devel/release" {
+		t.Errorf("search result = %+v", sources)
+	}
+
+	// 增量文本拼接后是完整的回复
+	if texts[0] != "Go 1.22" || texts[1] != " was released in February 2024." {
+		t.Errorf("texts = %q", texts)
+	}
+
+	var suggestions []string
+	if err := json.Unmarshal([]byte(messages[5].Text), &suggestions); err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 2 || suggestions[0] != "What's new in Go 1.22?" {
+		t.Errorf("suggested responses = %q", suggestions)
+	}
+}
+
+func TestAskStreamReplayErrors(t *testing.T) {
+	tests := []struct {
+		fixture string
+		text    string
+	}{
+		{"testdata/revoke.json", "Message revoke detected"},
+		{"testdata/throttled.json", "bing explicit error: value: Throttled; message: Request is throttled."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			messages := askReplay(t, tt.fixture)
+			last := messages[len(messages)-1]
+			if last.Type != MessageTypeError || last.Text != tt.text {
+				t.Errorf("last message = %+v, want error %q", last, tt.text)
+			}
+		})
+	}
+}
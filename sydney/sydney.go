@@ -1,24 +1,25 @@
 package sydney
 
 import (
+	"fmt"
 	"log/slog"
-	"strconv"
 
+	"github.com/cphovo/ollm/replay"
 	"github.com/cphovo/ollm/util"
 	"github.com/samber/lo"
 
 	"github.com/google/uuid"
-	clone "github.com/huandu/go-clone/generic"
 )
 
 type Sydney struct {
-	debug                 bool
-	proxy                 string
-	conversationStyle     string
-	locale                string
-	wssURL                string
-	createConversationURL string
-	bypassServer          string
+	debug             bool
+	proxy             string
+	conversationStyle string
+	locale            string
+	market            string
+	region            string
+	endpoints         Endpoints
+	captchaSolver     CaptchaSolver
 
 	optionsSet          []string
 	sliceIDs            []string
@@ -28,16 +29,18 @@ type Sydney struct {
 	cookies             map[string]string
 	gptID               string
 	plugins             []ArgumentPlugin
+	replay              *replay.Session
 }
 
-func NewSydney(options Options) *Sydney {
-	debugOptions := clone.Clone(options)
+func NewSydney(options Options) (*Sydney, error) {
+	debugOptions := options
 	debugOptions.Cookies = nil
+	debugOptions.Replay = nil
 	slog.Info("New Sydney", "v", debugOptions)
 
 	uuidObj, err := uuid.NewUUID()
 	if err != nil {
-		util.GracefulPanic(err)
+		return nil, err
 	}
 	optionsSet := []string{
 		"fluxcopilot",
@@ -61,11 +64,13 @@ func NewSydney(options Options) *Sydney {
 		"ldsummary",   // our guess: long document summary
 		"ldqa",        // our guess: long document quality assurance
 	}
-	forwardedIP := "1.0.0." + strconv.Itoa(util.RandIntInclusive(1, 255))
+	locale := util.Ternary(options.Locale == "", "en-US", options.Locale)
+	location := util.Ternary(options.Location == Location{}, LocationPresets[DefaultLocation], options.Location)
+	forwardedIP := location.forwardedIP()
 	cookies := util.Ternary(options.Cookies == nil, map[string]string{}, options.Cookies)
 	options.ConversationStyle = lo.Ternary(options.ConversationStyle == "",
 		"Creative", options.ConversationStyle)
-	gptID := "copilot"
+	gptID := DefaultPersona
 	switch options.ConversationStyle {
 	case "Balanced":
 		optionsSet = append(optionsSet, "galileo", "gldcl1p")
@@ -76,28 +81,38 @@ func NewSydney(options Options) *Sydney {
 			options.ConversationStyle = "CreativeClassic"
 		}
 	case "Designer":
-		optionsSet = append(optionsSet, "ai_persona_designer_gpt")
+		// 兼容旧的写法，Designer 现在是一个 persona
+		options.Persona = util.Ternary(options.Persona == "", "Designer", options.Persona)
 		options.ConversationStyle = "Creative"
-		gptID = "designer"
 	default:
 		slog.Warn("Conversation style not found", "param", options.ConversationStyle,
 			"fallback-to", "Creative")
 		options.ConversationStyle = "Creative"
 	}
+	if options.Persona != "" {
+		persona, ok := FindPersona(options.Persona)
+		if !ok {
+			return nil, fmt.Errorf("persona not found: %s", options.Persona)
+		}
+		optionsSet = append(optionsSet, persona.OptionsSets...)
+		gptID = persona.GptID
+	}
 	if options.NoSearch && len(options.Plugins) == 0 {
 		optionsSet = append(optionsSet, "nosearchall")
 	}
 	if options.GPT4Turbo && !options.UseClassic {
 		optionsSet = append(optionsSet, "gpt4tmncnp")
 	}
-	if debugOptionSets := util.ReadDebugOptionSets(); len(debugOptionSets) != 0 {
-		optionsSet = debugOptionSets
+	profiles := CurrentOptionSetProfiles()
+	if profile := util.Ternary(options.OptionSetProfile == "", profiles.Default, options.OptionSetProfile); profile != "" {
+		optionsSet, err = profiles.Apply(profile, optionsSet)
+		if err != nil {
+			return nil, err
+		}
 	}
 	var plugins []ArgumentPlugin
 	for _, pluginName := range options.Plugins {
-		plugin, ok := lo.Find(PluginList, func(item Plugin) bool {
-			return item.Name == pluginName
-		})
+		plugin, ok := FindPlugin(pluginName)
 		if !ok {
 			slog.Warn("Plugin not found", "name", pluginName)
 			continue
@@ -105,39 +120,32 @@ func NewSydney(options Options) *Sydney {
 		optionsSet = append(optionsSet, plugin.OptionsSets...)
 		plugins = append(plugins, plugin.ArgumentPlugin)
 	}
+	if options.WssDomain != "" {
+		options.Endpoints.ChatHub = "wss://" + options.WssDomain + "/sydney/ChatHub"
+	}
+	if options.CreateConversationURL != "" {
+		options.Endpoints.CreateConversation = options.CreateConversationURL
+	}
+	endpoints := options.Endpoints.withDefaults()
+	captchaSolver := options.CaptchaSolver
+	if captchaSolver == nil {
+		captchaSolver = util.Ternary[CaptchaSolver](options.BypassServer == "",
+			BrowserSolver{}, BypassServerSolver{URL: options.BypassServer})
+	}
 	slog.Info("Final conversation options", "options", optionsSet, "tone", options.ConversationStyle)
 	return &Sydney{
 		debug:             options.Debug,
 		proxy:             options.Proxy,
 		conversationStyle: options.ConversationStyle,
-		locale:            util.Ternary(options.Locale == "", "en-US", options.Locale),
-		wssURL: util.Ternary(options.WssDomain == "", "wss://sydney.bing.com/sydney/ChatHub",
-			"wss://"+options.WssDomain+"/sydney/ChatHub"),
-		createConversationURL: util.Ternary(options.CreateConversationURL == "",
-			"https://edgeservices.bing.com/edgesvc/turing/conversation/create", options.CreateConversationURL),
-		bypassServer: options.BypassServer,
-		optionsSet:   optionsSet,
-		sliceIDs:     []string{},
-		locationHint: LocationHint{
-			SourceType: 1,
-			RegionType: 2,
-			Center: LatLng{
-				Latitude:  33.97570037841797,
-				Longitude: -118.25640106201172,
-			},
-			Radius:                   24902,
-			Name:                     "Los Angeles, California",
-			Accuracy:                 24902,
-			FDConfidence:             0.5,
-			CountryName:              "United States",
-			CountryConfidence:        8,
-			Admin1Name:               "California",
-			PopulatedPlaceName:       "Los Angeles",
-			PopulatedPlaceConfidence: 5,
-			PostCodeName:             "90060",
-			UtcOffset:                -8,
-			Dma:                      803,
-		},
+		locale:            locale,
+		market:            util.Ternary(options.Market == "", locale, options.Market),
+		region:            util.Ternary(location.Region == "", "US", location.Region),
+		endpoints:         endpoints,
+		captchaSolver:     captchaSolver,
+		replay:            options.Replay,
+		optionsSet:        optionsSet,
+		sliceIDs:          []string{},
+		locationHint:      location.hint(),
 		allowedMessageTypes: []string{
 			"ActionRequest",
 			"Chat",
@@ -153,7 +161,7 @@ func NewSydney(options Options) *Sydney {
 		headers: func() map[string]string {
 			return map[string]string{
 				"accept":                      "application/json",
-				"accept-language":             "en-US,en;q=0.9",
+				"accept-language":             acceptLanguage(locale),
 				"content-type":                "application/json",
 				"sec-ch-ua":                   `"Microsoft Edge";v="113", "Chromium";v="113", "Not-A.Brand";v="24"`,
 				"sec-ch-ua-arch":              `"x86"`,
@@ -172,7 +180,7 @@ func NewSydney(options Options) *Sydney {
 				"x-ms-client-request-id":      uuidObj.String(),
 				"x-ms-useragent":              "azsdk-js-api-client-factory/1.0.0-beta.1 core-rest-pipeline/1.10.0 OS/Win32",
 				"user-agent":                  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/113.0.0.0 Safari/537.36 Edg/113.0.1774.50",
-				"Referer":                     "https://www.bing.com/search?q=Bing+AI&showconv=1",
+				"Referer":                     endpoints.Bing + "/search?q=Bing+AI&showconv=1",
 				"Referrer-Policy":             "origin-when-cross-origin",
 				"x-forwarded-for":             forwardedIP,
 				"Cookie":                      util.FormatCookieString(cookies),
@@ -181,5 +189,5 @@ func NewSydney(options Options) *Sydney {
 		cookies: cookies,
 		gptID:   gptID,
 		plugins: plugins,
-	}
+	}, nil
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/cphovo/ollm/replay"
 )
 
 const delimiter = '\x1e'
@@ -55,9 +57,12 @@ const (
 	MessageTypeExecutingTask      = "executing_task"
 	MessageTypeOpenAPICall        = "openapi_call"
 	MessageTypeGeneratedCode      = "generated_code"
+	MessageTypeExecutionOutput    = "execution_output"
+	MessageTypeGeneratedFile      = "generated_file"
 	MessageTypeResolvingCaptcha   = "resolving_captcha"
 	MessageTypeMessageText        = "message"
 	MessageTypeSuggestedResponses = "suggested_responses"
+	MessageTypeConversationID     = "conversation_id"
 	MessageTypeError              = "error"
 )
 
@@ -131,18 +136,34 @@ type PreviousMessage struct {
 	HiddenText  string `json:"hiddenText"`
 }
 type Options struct {
-	Debug                 bool
-	Cookies               map[string]string
-	Proxy                 string
-	ConversationStyle     string
-	Locale                string
+	Debug             bool
+	Cookies           map[string]string
+	Proxy             string
+	ConversationStyle string
+	// Defaults to en-US
+	Locale string
+	// Defaults to Locale
+	Market string
+	// Defaults to LocationPresets[DefaultLocation]
+	Location Location
+	// Shortcuts for Endpoints.ChatHub and Endpoints.CreateConversation
 	WssDomain             string
 	CreateConversationURL string
+	Endpoints             Endpoints
 	NoSearch              bool
 	UseClassic            bool
 	GPT4Turbo             bool
-	BypassServer          string
-	Plugins               []string
+	// Shortcut for CaptchaSolver: BypassServerSolver{URL: BypassServer}
+	BypassServer string
+	// Defaults to a visible BrowserSolver
+	CaptchaSolver CaptchaSolver
+	Plugins       []string
+	// Name of a Bing GPT in PersonaList, empty is Copilot
+	Persona string
+	// Option set profile, empty is the default one, see OptionSetProfiles
+	OptionSetProfile string
+	// Records or replays the upstream traffic, see the replay package
+	Replay *replay.Session
 }
 type AskStreamOptions struct {
 	StopCtx        context.Context
@@ -268,6 +289,14 @@ type ChatStreamRequest struct {
 	UseClassic        bool     `json:"classic"`
 	ConversationStyle string   `json:"conversationStyle"`
 	Plugins           []string `json:"plugins"`
+	// Name of a Bing GPT, see PersonaList
+	Persona string `json:"persona"`
+	// Option set profile, see OptionSetProfiles
+	OptionSets string `json:"optionSets"`
+	// Location preset, locale and market, see handler.BingLocale
+	Location string `json:"location"`
+	Locale   string `json:"locale"`
+	Market   string `json:"market"`
 }
 
 type OpenAIMessage struct {
@@ -283,16 +312,39 @@ type OpenAIMessagesParseResult struct {
 
 // Most fields are omitted due to limitations of the Bing API
 type OpenAIChatCompletionRequest struct {
-	Model        string                     `json:"model"`
-	Messages     []OpenAIMessage            `json:"messages"`
-	Stream       bool                       `json:"stream"`
-	ToolChoice   *interface{}               `json:"tool_choice"`
-	Conversation CreateConversationResponse `json:"conversation"`
+	Model          string                     `json:"model"`
+	Messages       []OpenAIMessage            `json:"messages"`
+	Stream         bool                       `json:"stream"`
+	ToolChoice     *interface{}               `json:"tool_choice"`
+	Conversation   CreateConversationResponse `json:"conversation"`
+	ConversationID string                     `json:"conversation_id"`
+	// Bing only: plugins and GPT persona, added to the ones of the model
+	Plugins []string `json:"plugins"`
+	Persona string   `json:"persona"`
+	// Bing only: option set profile, defaults to the one of the model
+	OptionSets string `json:"option_sets"`
+	// Bing only: location preset, locale and market
+	Location string `json:"location"`
+	Locale   string `json:"locale"`
+	Market   string `json:"market"`
+	// Bing only: footnote (default) or markdown
+	CitationFormat string `json:"citation_format"`
+	// Bing only: send the intermediate events in delta.event
+	Events bool `json:"events"`
+	// Generate suggested responses when Bing sends none
+	Suggestions bool `json:"suggestions"`
 }
 
 type ChoiceDelta struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role        string       `json:"role"`
+	Content     string       `json:"content"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+	Event       *StreamEvent `json:"event,omitempty"`
+	// Sent in the last chunk
+	SuggestedResponses []string `json:"suggested_responses,omitempty"`
+	// Code interpreter runs and the images and files they produced, sent in the last chunk
+	CodeInterpreter []CodeInterpreterRun `json:"code_interpreter,omitempty"`
+	ContentParts    []ContentPart        `json:"content_parts,omitempty"`
 }
 
 type ChatCompletionChunkChoice struct {
@@ -311,8 +363,13 @@ type OpenAIChatCompletionChunk struct {
 }
 
 type ChoiceMessage struct {
-	Content string `json:"content"`
-	Role    string `json:"role"`
+	Content            string               `json:"content"`
+	Role               string               `json:"role"`
+	Annotations        []Annotation         `json:"annotations,omitempty"`
+	Events             []StreamEvent        `json:"events,omitempty"`
+	SuggestedResponses []string             `json:"suggested_responses,omitempty"`
+	CodeInterpreter    []CodeInterpreterRun `json:"code_interpreter,omitempty"`
+	ContentParts       []ContentPart        `json:"content_parts,omitempty"`
 }
 
 type UsageStats struct {
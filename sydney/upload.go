@@ -24,7 +24,7 @@ func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	client.SetCommonHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1&FORM=hpcodx")
+	client.SetCommonHeader("Referer", o.endpoints.Bing+"/search?q=Bing+AI&showconv=1&FORM=hpcodx")
 	imageBase64 := base64.StdEncoding.EncodeToString(jpgImgData)
 	uploadImagePayload := UploadImagePayload{
 		ImageInfo: map[string]any{},
@@ -47,7 +47,7 @@ func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
 	resp, err := client.R().EnableForceMultipart().SetFormData(map[string]string{
 		"knowledgeRequest": string(payload),
 		"imageBase64":      imageBase64,
-	}).Post("https://www.bing.com/images/kblob")
+	}).Post(o.endpoints.Bing + "/images/kblob")
 	if err != nil {
 		return "", fmt.Errorf("cannot fire upload request: %w", err)
 	}
@@ -59,7 +59,7 @@ func (o *Sydney) UploadImage(jpgImgData []byte) (string, error) {
 	if result.BlobId == "" {
 		return "", errors.New("blobId is empty")
 	}
-	return "https://www.bing.com/images/blob?bcid=" + result.BlobId, nil
+	return o.endpoints.Bing + "/images/blob?bcid=" + result.BlobId, nil
 }
 
 func (o *Sydney) uploadFile(uploadFilePath string, conversation CreateConversationResponse) (UploadFileResult, error) {
@@ -82,8 +82,8 @@ func (o *Sydney) uploadFile(uploadFilePath string, conversation CreateConversati
 	var response UploadFileResponse
 	resp, err := client.R().
 		SetHeader("Authorization", "Bearer "+conversation.BearerToken).
-		SetHeader("Referer", "https://www.bing.com/search?q=Bing+AI&showconv=1").
-		SetHeader("Origin", "https://www.bing.com").
+		SetHeader("Referer", o.endpoints.Bing+"/search?q=Bing+AI&showconv=1").
+		SetHeader("Origin", o.endpoints.Bing).
 		SetFileUpload(req.FileUpload{
 			ParamName: "file",
 			FileName:  filepath.Base(uploadFilePath),
@@ -97,7 +97,7 @@ func (o *Sydney) uploadFile(uploadFilePath string, conversation CreateConversati
 		"tone":                        o.conversationStyle,
 		"userId":                      conversation.ClientId,
 		"enableFileUploadLongContext": "true",
-	}).SetSuccessResult(&response).Post("https://sydney.bing.com/sydney/UploadFile")
+	}).SetSuccessResult(&response).Post(o.endpoints.Sydney + "/sydney/UploadFile")
 	if err != nil {
 		return empty, err
 	}
@@ -3,13 +3,24 @@ package sydney
 import (
 	"errors"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/cphovo/ollm/replay"
 	"github.com/cphovo/ollm/util"
 	"nhooyr.io/websocket"
 )
 
+// frameConn reads and writes delimited SignalR messages, it is replaced by
+// recorded frames in replay mode.
+type frameConn interface {
+	WriteWithTimeout(v []byte) error
+	ReadWithTimeout() ([]string, error)
+	CloseNow() error
+}
+
 type Conn struct {
 	debug bool
 	*websocket.Conn
@@ -46,3 +57,75 @@ func (o *Conn) ReadWithTimeout() ([]string, error) {
 	}
 	return arr, nil
 }
+
+// connect dials the chat hub, or serves the recorded frames when replaying
+func (o *Sydney) connect(conversation CreateConversationResponse) (frameConn, error) {
+	if o.replay != nil && !o.replay.Recording() {
+		return &replayConn{session: o.replay}, nil
+	}
+	client, _, err := util.MakeHTTPClient(o.proxy, 0)
+	if err != nil {
+		return nil, err
+	}
+	httpHeaders := http.Header{}
+	for k, v := range o.headers() {
+		httpHeaders.Set(k, v)
+	}
+	ctx, cancel := util.CreateTimeoutContext(10 * time.Second)
+	defer cancel()
+	connRaw, resp, err := websocket.Dial(ctx,
+		o.endpoints.ChatHub+util.Ternary(conversation.SecAccessToken != "", "?sec_access_token="+
+			url.QueryEscape(conversation.SecAccessToken), ""),
+		&websocket.DialOptions{
+			HTTPClient: client,
+			HTTPHeader: httpHeaders,
+		})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 101 {
+		connRaw.CloseNow()
+		return nil, errors.New("cannot establish a websocket connection")
+	}
+	connRaw.SetReadLimit(-1)
+	conn := &Conn{Conn: connRaw, debug: o.debug}
+	if o.replay != nil {
+		return &recordingConn{Conn: conn, session: o.replay}, nil
+	}
+	return conn, nil
+}
+
+// recordingConn keeps every message read from the server
+type recordingConn struct {
+	*Conn
+	session *replay.Session
+}
+
+func (o *recordingConn) ReadWithTimeout() ([]string, error) {
+	arr, err := o.Conn.ReadWithTimeout()
+	if err == nil && arr != nil {
+		o.session.RecordFrame(strings.Join(arr, string(delimiter)))
+	}
+	return arr, err
+}
+
+// replayConn drops what is written and reads the recorded frames in order
+type replayConn struct {
+	session *replay.Session
+}
+
+func (o *replayConn) WriteWithTimeout(v []byte) error {
+	return nil
+}
+
+func (o *replayConn) ReadWithTimeout() ([]string, error) {
+	frame, err := o.session.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(frame, string(delimiter)), nil
+}
+
+func (o *replayConn) CloseNow() error {
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build desktop
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/ncruces/zenity"
+	"github.com/samber/lo"
+)
+
+// GracefulPanic shows the error in a dialog, opens the issues page and exits.
+// Only for desktop builds (go build -tags desktop).
+func GracefulPanic(err error) {
+	_, file, line, _ := runtime.Caller(1)
+	zenity.Error(fmt.Sprintf("Error: %v\nDetails: file(%s), line(%d).\n"+
+		"Instruction: This is probably an unknown bug. Please take a screenshot and report this issue.",
+		err, file, line))
+	lo.Must0(OpenURL("https://github.com/juzeon/SydneyQt/issues"))
+	os.Exit(-1)
+}
+func OpenURL(url string) error {
+	var cmd string
+	var args []string
+
+	switch runtime.GOOS {
+	case "windows":
+		cmd = "cmd"
+		args = []string{"/c", "start"}
+	case "darwin":
+		cmd = "open"
+	default: // "linux", "freebsd", "openbsd", "netbsd"
+		cmd = "xdg-open"
+	}
+	args = append(args, url)
+	return exec.Command(cmd, args...).Start()
+}
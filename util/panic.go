@@ -0,0 +1,16 @@
+//go:build !desktop
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// GracefulPanic panics with the error and where it happened. On the server
+// the panic is turned into a 500 by the recovery middleware instead of
+// killing the process, see util/desktop.go for the desktop behaviour.
+func GracefulPanic(err error) {
+	_, file, line, _ := runtime.Caller(1)
+	panic(fmt.Errorf("%w (file %s, line %d)", err, file, line))
+}
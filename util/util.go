@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"image"
 	_ "image/gif"
 	"image/jpeg"
@@ -20,18 +19,15 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/imroc/req/v3"
 
-	"github.com/samber/lo"
-
-	"github.com/ncruces/zenity"
 	getproxy "github.com/rapid7/go-get-proxied/proxy"
 )
 
@@ -220,47 +216,6 @@ func GenerateSecMSGec() string {
 	// Convert to hexadecimal
 	return hex.EncodeToString(randomBytes)
 }
-func GracefulPanic(err error) {
-	_, file, line, _ := runtime.Caller(1)
-	zenity.Error(fmt.Sprintf("Error: %v\nDetails: file(%s), line(%d).\n"+
-		"Instruction: This is probably an unknown bug. Please take a screenshot and report this issue.",
-		err, file, line))
-	lo.Must0(OpenURL("https://github.com/juzeon/SydneyQt/issues"))
-	os.Exit(-1)
-}
-func OpenURL(url string) error {
-	var cmd string
-	var args []string
-
-	switch runtime.GOOS {
-	case "windows":
-		cmd = "cmd"
-		args = []string{"/c", "start"}
-	case "darwin":
-		cmd = "open"
-	default: // "linux", "freebsd", "openbsd", "netbsd"
-		cmd = "xdg-open"
-	}
-	args = append(args, url)
-	return exec.Command(cmd, args...).Start()
-}
-func ReadDebugOptionSets() (debugOptionsSets []string) {
-	debugOptionsSetsFile, err := os.ReadFile(WithPath("debug_options_sets.json"))
-	if err != nil {
-		return
-	}
-	if strings.TrimSpace(string(debugOptionsSetsFile)) == "" {
-		return
-	}
-	err = json.Unmarshal(debugOptionsSetsFile, &debugOptionsSets)
-	if err != nil {
-		GracefulPanic(err)
-	}
-	if len(debugOptionsSets) != 0 {
-		slog.Warn("Enable debug options sets", "v", debugOptionsSets)
-	}
-	return
-}
 
 var initWithPath = sync.OnceFunc(func() {
 	if runtime.GOOS == "darwin" {
@@ -299,3 +254,19 @@ func ParseCookies(cookiesStr string) map[string]string {
 	}
 	return cookies
 }
+
+// EstimateTokens roughly counts tokens the way OpenAI tokenizers tend to:
+// one token per CJK character and about four characters per token otherwise.
+// None of the upstreams report usage, so this is only an estimate.
+func EstimateTokens(text string) int {
+	tokens, others := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			tokens++
+			continue
+		}
+		others++
+	}
+	return tokens + (others+3)/4
+}